@@ -0,0 +1,277 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+var (
+	addrIndexPrefix = []byte("addr-")
+	// addrIndexFlag is set once the address index covers the main chain, it is kept up to date from then on.
+	addrIndexFlag = []byte("addrindex")
+
+	ErrNoAddrIndex = errors.New("chain has no address index")
+)
+
+const (
+	addrSpent    = 'i'
+	addrReceived = 'o'
+)
+
+// AddressEntry is a change of the balance of an address: an output it received or an output of it an input spent.
+type AddressEntry struct {
+	Height  int
+	TxID    []byte
+	Index   int  // index of the output received or of the input spending
+	Spent   bool // the entry is an input spending an output of the address
+	Amount  int
+	Balance int // balance of the address after the entry
+}
+
+// addrKey sorts the entries of an address in the order of the chain: by height, position of the transaction in the
+// block, inputs before outputs and then index.
+func addrKey(pubKeyHash []byte, height, position int, direction byte, index int) []byte {
+	key := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+	key = binary.BigEndian.AppendUint64(key, uint64(height))
+	key = binary.BigEndian.AppendUint32(key, uint32(position))
+	key = append(key, direction)
+
+	return binary.BigEndian.AppendUint32(key, uint32(index))
+}
+
+func addrIndexed(txn storage.Reader) (bool, error) {
+	_, err := txn.Get(addrIndexFlag)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// addrEntries calls f with the key and value of every entry of a block. spent holds the outputs spent by the
+// inputs of the block, in order.
+func addrEntries(block *Block, spent []TxOutput, f func(key, value []byte) error) error {
+	for position, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for i := range tx.Inputs {
+				if len(spent) == 0 {
+					return fmt.Errorf("missing spent outputs of block %x", block.Hash)
+				}
+				out := spent[0]
+				spent = spent[1:]
+				if len(out.PubKeyHash) == 0 {
+					continue
+				}
+
+				key := addrKey(out.PubKeyHash, block.Height, position, addrSpent, i)
+				if err := f(key, addrValue(tx.ID, out.Value)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, out := range tx.Outputs {
+			if len(out.PubKeyHash) == 0 {
+				continue
+			}
+
+			key := addrKey(out.PubKeyHash, block.Height, position, addrReceived, i)
+			if err := f(key, addrValue(tx.ID, out.Value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addrValue(txID []byte, amount int) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, txID...), uint64(amount))
+}
+
+// spentOutputs looks up the outputs spent by the inputs of a block in the UTXO set, or in the block itself for the
+// outputs of its own transactions. The UTXO set must not include the block.
+func spentOutputs(txn storage.Reader, block *Block) ([]TxOutput, error) {
+	var spent []TxOutput
+	created := make(map[string]*Transaction)
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				if prevTx, ok := created[string(in.ID)]; ok {
+					spent = append(spent, prevTx.Outputs[in.Out])
+					continue
+				}
+
+				v, err := txn.Get(utxoKey(in.ID))
+				if err != nil {
+					return nil, fmt.Errorf("output %x:%d: %w", in.ID, in.Out, err)
+				}
+				outs, err := DeserializeOutputs(v)
+				if err != nil {
+					return nil, err
+				}
+				out, ok := outs.Outputs[in.Out]
+				if !ok {
+					return nil, fmt.Errorf("output %x:%d is spent", in.ID, in.Out)
+				}
+				spent = append(spent, out)
+			}
+		}
+		created[string(tx.ID)] = tx
+	}
+
+	return spent, nil
+}
+
+// indexAddresses adds the entries of a block joining the main chain to the address index, when the chain has one.
+// It runs before the UTXO set is updated with the block.
+func indexAddresses(txn storage.Txn, block *Block) error {
+	indexed, err := addrIndexed(txn)
+	if err != nil || !indexed {
+		return err
+	}
+
+	spent, err := spentOutputs(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return addrEntries(block, spent, txn.Put)
+}
+
+// unindexAddresses removes the entries of a block leaving the main chain from the address index. It runs once the
+// UTXO set has been reverted.
+func unindexAddresses(txn storage.Txn, block *Block) error {
+	indexed, err := addrIndexed(txn)
+	if err != nil || !indexed {
+		return err
+	}
+
+	spent, err := spentOutputs(txn, block)
+	if err != nil {
+		return err
+	}
+
+	return addrEntries(block, spent, func(key, _ []byte) error {
+		return txn.Delete(key)
+	})
+}
+
+// HasAddrIndex reports whether the chain keeps an address index.
+func (chain *BlockChain) HasAddrIndex() (bool, error) {
+	return addrIndexed(chain.Database)
+}
+
+// ReindexAddresses rebuilds the address index from the main chain and keeps it up to date from then on. The
+// outputs spent by a block come from its undo record. It returns the number of entries indexed.
+func (chain *BlockChain) ReindexAddresses() (int, error) {
+	if err := chain.Database.Delete(addrIndexFlag); err != nil {
+		return 0, err
+	}
+
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.DeleteByPrefix(addrIndexPrefix); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		undo, err := UTXOSet.blockUndo(block)
+		if err != nil {
+			return 0, err
+		}
+		err = chain.Database.Update(func(txn storage.Txn) error {
+			return addrEntries(block, undo.Spent, func(key, value []byte) error {
+				count++
+
+				return txn.Put(key, value)
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return count, chain.Database.Put(addrIndexFlag, []byte{})
+}
+
+// AddressHistory returns the entries of the address of pubKeyHash from the oldest, with the running balance, and
+// the total number of entries. skip entries are left out and at most count returned, all of them when count is 0.
+// It returns ErrNoAddrIndex when the chain has no address index.
+func (chain *BlockChain) AddressHistory(pubKeyHash []byte, skip, count int) ([]AddressEntry, int, error) {
+	var history []AddressEntry
+	total := 0
+
+	err := chain.Database.View(func(txn storage.Reader) error {
+		indexed, err := addrIndexed(txn)
+		if err != nil {
+			return err
+		}
+		if !indexed {
+			return ErrNoAddrIndex
+		}
+
+		prefix := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+		balance := 0
+
+		return txn.Iterate(prefix, func(key, v []byte) error {
+			entry, err := parseAddrEntry(bytes.TrimPrefix(key, prefix))
+			if err != nil {
+				return err
+			}
+			if len(v) < 8 {
+				return fmt.Errorf("malformed address index entry %x", v)
+			}
+			entry.TxID = v[:len(v)-8]
+			entry.Amount = int(binary.BigEndian.Uint64(v[len(v)-8:]))
+
+			if entry.Spent {
+				balance -= entry.Amount
+			} else {
+				balance += entry.Amount
+			}
+			entry.Balance = balance
+
+			if total >= skip && (count == 0 || len(history) < count) {
+				history = append(history, entry)
+			}
+			total++
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return history, total, nil
+}
+
+// parseAddrEntry reads an entry from its key without the prefix and public key hash.
+func parseAddrEntry(key []byte) (AddressEntry, error) {
+	if len(key) != 8+4+1+4 {
+		return AddressEntry{}, fmt.Errorf("malformed address index key %x", key)
+	}
+
+	return AddressEntry{
+		Height: int(binary.BigEndian.Uint64(key)),
+		Index:  int(binary.BigEndian.Uint32(key[13:])),
+		Spent:  key[12] == addrSpent,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// historyOf returns the entries of the address of w as height, sign, amount and balance.
+func historyOf(t *testing.T, chain *BlockChain, w *wallet.Wallet, skip, count int) ([]string, int) {
+	t.Helper()
+
+	entries, total, err := chain.AddressHistory(wallet.PublicKeyHash(w.PublicKey), skip, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list []string
+	for _, entry := range entries {
+		sign := "+"
+		if entry.Spent {
+			sign = "-"
+		}
+		list = append(list, fmt.Sprintf("%d %s%d=%d", entry.Height, sign, entry.Amount, entry.Balance))
+	}
+
+	return list, total
+}
+
+func TestAddressHistory(t *testing.T) {
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	miner := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{AddrIndex: true})
+	genesis := tip(t, chain)
+
+	toAlice := pay(t, w, genesis.Transactions[0], 0, alice, 15)
+	addBlock(t, chain, miner, toAlice)
+
+	// w spends in the block the output alice pays it in the same block.
+	toW := pay(t, alice, toAlice, 0, w, 10)
+	back := pay(t, w, toW, 0, alice, 8)
+	addBlock(t, chain, miner, toW, back)
+
+	tests := []struct {
+		name        string
+		w           *wallet.Wallet
+		skip, count int
+		want        []string
+	}{
+		{"whole history", w, 0, 0, []string{"0 +20=20", "1 -20=0", "2 +10=10", "2 -10=0"}},
+		{"other address", alice, 0, 0, []string{"1 +15=15", "2 -15=0", "2 +8=8"}},
+		{"page", w, 1, 2, []string{"1 -20=0", "2 +10=10"}},
+		{"last page", w, 3, 2, []string{"2 -10=0"}},
+		{"past the end", w, 4, 2, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, total := historyOf(t, chain, test.w, test.skip, test.count)
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("history %v, want %v", got, test.want)
+			}
+			if whole, _ := historyOf(t, chain, test.w, 0, 0); total != len(whole) {
+				t.Errorf("total %d, want %d", total, len(whole))
+			}
+		})
+	}
+
+	entries, _, err := chain.AddressHistory(wallet.PublicKeyHash(w.PublicKey), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := entries[0]; !bytes.Equal(entry.TxID, toAlice.ID) || entry.Index != 0 || !entry.Spent {
+		t.Errorf("spending entry %+v, want input 0 of %x", entry, toAlice.ID)
+	}
+
+	if _, err := chain.DisconnectTip(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := historyOf(t, chain, w, 0, 0); fmt.Sprint(got) != fmt.Sprint([]string{"0 +20=20", "1 -20=0"}) {
+		t.Errorf("history after disconnecting %v", got)
+	}
+	if got, _ := historyOf(t, chain, alice, 0, 0); fmt.Sprint(got) != fmt.Sprint([]string{"1 +15=15"}) {
+		t.Errorf("history of alice after disconnecting %v", got)
+	}
+}
+
+func TestReindexAddresses(t *testing.T) {
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	if _, _, err := chain.AddressHistory(wallet.PublicKeyHash(w.PublicKey), 0, 0); !errors.Is(err, ErrNoAddrIndex) {
+		t.Fatalf("AddressHistory = %v, want %v", err, ErrNoAddrIndex)
+	}
+
+	toAlice := pay(t, w, genesis.Transactions[0], 0, alice, 15)
+	addBlock(t, chain, w, toAlice)
+	addBlock(t, chain, w, pay(t, alice, toAlice, 0, w, 10))
+
+	count, err := chain.ReindexAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 coinbases, 2 payments and the 2 inputs spending them.
+	if count != 7 {
+		t.Errorf("indexed %d entries, want 7", count)
+	}
+
+	// the coinbase comes first in its block.
+	want := []string{"0 +20=20", "1 +20=40", "1 -20=20", "2 +20=40", "2 +10=50"}
+	if got, _ := historyOf(t, chain, w, 0, 0); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("history %v", got)
+	}
+}
@@ -2,41 +2,92 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/gob"
+	"fmt"
 	"log"
+	"time"
 )
 
+// BlockVersion is the version of the header format produced by this node. Version 0 is the header of the first
+// release, kept by the genesis blocks it mined: its proof of work covers the parent hash, the hash of the
+// transaction IDs and the nonce under the fixed Difficulty. Version 1 adds the fields below but the witness root,
+// version 2 adds the witness root.
+const BlockVersion = 2
+
+// BlockHeader holds everything the proof of work commits to. The transactions are committed through the merkle root
+// of their IDs, which leave the signatures out, and the signatures through the witness root of the full hashes.
+type BlockHeader struct {
+	Version     int
+	PrevHash    []byte
+	MerkleRoot  []byte
+	WitnessRoot []byte
+	Timestamp   int64  // unix time in seconds
+	Bits        uint32 // target in compact form
+	Nonce       int
+	Height      int
+}
+
 type Block struct {
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
-	block := &Block{[]byte{}, txs, prevHash, 0}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// CreateBlock fills in the version and merkle root of the header then mines the block with a Miner using its
+// defaults. The caller sets the link to the parent, the height, the timestamp and the target bits.
+func CreateBlock(header BlockHeader, txs []*Transaction) (*Block, error) {
+	var miner Miner
 
-	return block
+	return miner.Mine(context.Background(), header, txs)
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+// Genesis mines the first block of a chain with the genesis target of params.
+func Genesis(coinbase *Transaction, params *Params) (*Block, error) {
+	header := BlockHeader{
+		PrevHash:  []byte{},
+		Timestamp: time.Now().Unix(),
+		Bits:      params.GenesisBits,
+		Height:    0,
+	}
+
+	return CreateBlock(header, []*Transaction{coinbase})
 }
 
+// HashTransactions returns the merkle root of the transaction IDs.
 func (b *Block) HashTransactions() []byte {
 	var txHashes [][]byte
-	var txHash [32]byte
 
 	for _, tx := range b.Transactions {
 		txHashes = append(txHashes, tx.ID)
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
+	tree := NewMerkleTree(txHashes)
+
+	return tree.RootNode.Data
+}
+
+// HashWitnesses returns the merkle root of the full transaction hashes, signatures included.
+func (b *Block) HashWitnesses() []byte {
+	var txHashes [][]byte
+
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.Hash())
+	}
+	tree := NewMerkleTree(txHashes)
+
+	return tree.RootNode.Data
+}
+
+// legacyHashTransactions is the hash of the transaction IDs the first release committed to instead of a merkle
+// root.
+func (b *Block) legacyHashTransactions() []byte {
+	var txHashes [][]byte
+
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.ID)
+	}
+	txHash := sha256.Sum256(bytes.Join(txHashes, []byte{}))
 
 	return txHash[:]
 }
@@ -46,25 +97,44 @@ func (b *Block) Serialize() []byte {
 	encoder := gob.NewEncoder(&res)
 
 	err := encoder.Encode(b)
-
-	Handle(err)
+	mustEncode(err)
 
 	return res.Bytes()
 }
 
-func Deserialize(data []byte) *Block {
-	var block Block
+// Deserialize decodes a block produced by Serialize or stored by the first release, whose blocks kept the parent
+// hash and the nonce next to the transactions and were all mined with the target of Difficulty.
+func Deserialize(data []byte) (*Block, error) {
+	var stored struct {
+		BlockHeader
+		Hash         []byte
+		Transactions []*Transaction
+		PrevHash     []byte
+		Nonce        int
+	}
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 
-	err := decoder.Decode(&block)
+	if err := decoder.Decode(&stored); err != nil {
+		return nil, fmt.Errorf("decoding block: %w", err)
+	}
 
-	Handle(err)
+	block := Block{stored.BlockHeader, stored.Hash, stored.Transactions}
+	if block.Version == 0 {
+		if stored.PrevHash != nil {
+			block.PrevHash = stored.PrevHash
+		}
+		if stored.Nonce != 0 {
+			block.Nonce = stored.Nonce
+		}
+		block.Bits = InitialBits
+	}
 
-	return &block
+	return &block, nil
 }
 
-func Handle(err error) {
+// mustEncode panics when gob fails to encode one of the package types, which only happens on a programming error.
+func mustEncode(err error) {
 	if err != nil {
 		log.Panic(err)
 	}
@@ -4,116 +4,276 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"runtime"
+	"time"
 
-	"github.com/dgraph-io/badger"
+	"github.com/tensor-programming/golang-blockchain/storage"
 )
 
-const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
-	genesisData = "First Transaction from Genesis"
+const genesisData = "First Transaction from Genesis"
+
+var (
+	ErrNoChain       = errors.New("no existing blockchain found, create one")
+	ErrChainExists   = errors.New("blockchain already exists")
+	ErrBlockNotFound = errors.New("block not found")
+	ErrTxNotFound    = errors.New("transaction not found")
 )
 
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
+	Database storage.Store
+	Params   *Params // consensus rules, DefaultParams when nil
 }
 
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    storage.Store
 }
 
-func DBexists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		return false
+// ContinueBlockChain opens the existing chain of the network of opts. It returns ErrNoChain when there is none. The
+// UTXO set of a chain stored by the first release is rebuilt in the current layout, and the main chain moved to the
+// heaviest stored branch when a reorganization was cut short.
+func ContinueBlockChain(opts Options) (*BlockChain, error) {
+	params, err := NetworkParams(opts.network())
+	if err != nil {
+		return nil, err
 	}
 
-	return true
-}
+	if DBexists(opts) == false {
+		return nil, ErrNoChain
+	}
 
-func ContinueBlockChain() *BlockChain {
-	if DBexists() == false {
-		fmt.Println("No existing blockchain found, create one!")
-		runtime.Goexit()
+	db, err := opts.openStore()
+	if err != nil {
+		return nil, err
 	}
 
-	var lastHash []byte
+	lastHash, err := db.Get([]byte("lh"))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	db, err := badger.Open(badger.DefaultOptions(dbPath))
-	Handle(err)
+	chain := BlockChain{LastHash: lastHash, Database: db, Params: params}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.ValueCopy(nil)
+	if err := chain.indexHeights(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		return err
-	})
-	Handle(err)
+	UTXOSet := UTXOSet{&chain}
+	legacy, err := UTXOSet.hasLegacyLayout()
+	if err == nil && legacy {
+		err = UTXOSet.Reindex()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	chain := BlockChain{lastHash, db}
+	if err := chain.activateBestChain(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	return &chain
+	if opts.TxIndex {
+		indexed, err := chain.HasTxIndex()
+		if err == nil && !indexed {
+			_, err = chain.ReindexTxs()
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if opts.AddrIndex {
+		indexed, err := chain.HasAddrIndex()
+		if err == nil && !indexed {
+			_, err = chain.ReindexAddresses()
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &chain, nil
 }
 
-func InitBlockChain(address string) *BlockChain {
+// InitBlockChain creates a chain for the network of opts, its genesis block paying address. It returns
+// ErrChainExists when there is one already.
+func InitBlockChain(address string, opts Options) (*BlockChain, error) {
 	var lastHash []byte
 
-	if DBexists() {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+	params, err := NetworkParams(opts.network())
+	if err != nil {
+		return nil, err
 	}
 
-	db, err := badger.Open(badger.DefaultOptions(dbPath))
-	Handle(err)
+	if DBexists(opts) {
+		return nil, ErrChainExists
+	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		genesis := Genesis(CoinBaseTx(address, genesisData))
-		fmt.Println("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
+	genesis, err := Genesis(CoinBaseTx(address, genesisData, params.GetBlockSubsidy(0)), params)
+	if err != nil {
+		return nil, err
+	}
 
-		lastHash = genesis.Hash
+	db, err := opts.openStore()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(txn storage.Txn) error {
+		if err := txn.Put(genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		if err := setCumulativeWork(txn, genesis.Hash, BlockWork(genesis.Bits)); err != nil {
+			return err
+		}
+		if err := setHeight(txn, genesis.Height, genesis.Hash); err != nil {
+			return err
+		}
+		if opts.TxIndex {
+			if err := txn.Put(txIndexFlag, []byte{}); err != nil {
+				return err
+			}
+			if err := setTxLocations(txn, genesis); err != nil {
+				return err
+			}
+		}
+		if opts.AddrIndex {
+			if err := txn.Put(addrIndexFlag, []byte{}); err != nil {
+				return err
+			}
+			if err := addrEntries(genesis, nil, txn.Put); err != nil {
+				return err
+			}
+		}
+		if err := updateUTXO(txn, genesis); err != nil {
+			return err
+		}
 
-		return err
+		lastHash = genesis.Hash
 
+		return txn.Put([]byte("lh"), genesis.Hash)
 	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	Handle(err)
+	blockchain := BlockChain{LastHash: lastHash, Database: db, Params: params}
+	return &blockchain, nil
+}
+
+// NextHeader prepares the header of a block extending the current tip: parent, height, target bits and a timestamp
+// past the median time past even if the clock is behind. The miner fills in the rest.
+func (chain *BlockChain) NextHeader() (BlockHeader, error) {
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return BlockHeader{}, err
+	}
 
-	blockchain := BlockChain{lastHash, db}
-	return &blockchain
+	medianTime, err := chain.MedianTimePast(lastBlock.Hash)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	timestamp := time.Now().Unix()
+	if timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+
+	bits, err := chain.NextBits(&lastBlock)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return BlockHeader{
+		PrevHash:  lastBlock.Hash,
+		Timestamp: timestamp,
+		Bits:      bits,
+		Height:    lastBlock.Height + 1,
+	}, nil
 }
 
-func (chain *BlockChain) AddBlock(transactions []*Transaction) {
-	var lastHash []byte
+// AddBlock mines a new block with the given transactions on top of the current tip and connects it with
+// ConnectBlock.
+func (chain *BlockChain) AddBlock(transactions []*Transaction) (*Block, error) {
+	header, err := chain.NextHeader()
+	if err != nil {
+		return nil, err
+	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		Handle(err)
-		lastHash, err = item.ValueCopy(nil)
+	newBlock, err := CreateBlock(header, transactions)
+	if err != nil {
+		return nil, err
+	}
 
-		return err
-	})
-	Handle(err)
+	if err := chain.ConnectBlock(newBlock); err != nil {
+		return nil, err
+	}
 
-	newBlock := CreateBlock(transactions, lastHash)
+	return newBlock, nil
+}
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+// HasBlock reports whether a block with the given hash is stored.
+func (chain *BlockChain) HasBlock(blockHash []byte) bool {
+	_, err := chain.Database.Get(blockHash)
 
-		chain.LastHash = newBlock.Hash
+	return err == nil
+}
 
-		return err
-	})
-	Handle(err)
+// GetBlock fetches a block by its hash. The error wraps ErrBlockNotFound when there is no such block.
+func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
+	blockData, err := chain.Database.Get(blockHash)
+	if err == storage.ErrNotFound {
+		return Block{}, fmt.Errorf("%w: %x", ErrBlockNotFound, blockHash)
+	}
+	if err != nil {
+		return Block{}, err
+	}
+
+	block, err := Deserialize(blockData)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return *block, nil
+}
+
+// GetBlockHashes returns the hashes of every block in the main chain, from the tip down to genesis.
+func (chain *BlockChain) GetBlockHashes() ([][]byte, error) {
+	var blocks [][]byte
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block.Hash)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return blocks, nil
+}
+
+// GetBestHeight returns the height of the tip, genesis being at height 0.
+func (chain *BlockChain) GetBestHeight() (int, error) {
+	lastBlock, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastBlock.Height, nil
 }
 
 func (chain *BlockChain) Iterator() *BlockChainIterator {
@@ -122,27 +282,30 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 	return iter
 }
 
-func (iter *BlockChainIterator) Next() *Block {
-	var block *Block
-
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		Handle(err)
-		encodedBlock, err := item.ValueCopy(nil)
-		block = Deserialize(encodedBlock)
+// Next returns the current block and moves to its parent. The caller stops after the genesis block, the one with an
+// empty PrevHash.
+func (iter *BlockChainIterator) Next() (*Block, error) {
+	encodedBlock, err := iter.Database.Get(iter.CurrentHash)
+	if err == storage.ErrNotFound {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, iter.CurrentHash)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		return err
-	})
-	Handle(err)
+	block, err := Deserialize(encodedBlock)
+	if err != nil {
+		return nil, err
+	}
 
 	iter.CurrentHash = block.PrevHash
 
-	return block
+	return block, nil
 }
 
 // FindUnspentTransactions trying to find unspend output for given address. That transaction that has output and are not
 // referenced by other inputs. It means there are tokens available for user. Find all output transaction for the given user that holds given address.
-func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
+func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) ([]Transaction, error) {
 	var unspentTxs []Transaction
 
 	spentTXOs := make(map[string][]int)
@@ -150,7 +313,10 @@ func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transactio
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -183,10 +349,11 @@ func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transactio
 			break
 		}
 	}
-	return unspentTxs
+	return unspentTxs, nil
 }
 
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
+// FindUTXO walks the whole main chain and collects the outputs no input spends, keyed by transaction ID.
+func (chain *BlockChain) FindUTXO() (map[string]TxOutputs, error) {
 	UTXO := make(map[string]TxOutputs)
 	// key is txID and value is out index.
 	spentTXOs := make(map[string][]int)
@@ -194,7 +361,10 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 	iterator := chain.Iterator()
 
 	for {
-		block := iterator.Next()
+		block, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 		Outputs:
@@ -206,8 +376,11 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 						}
 					}
 				}
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				outs, ok := UTXO[txID]
+				if !ok {
+					outs = NewTxOutputs(nil)
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 
@@ -224,47 +397,78 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 		}
 	}
 
-	return UTXO
+	return UTXO, nil
+}
+
+// FindTx by transaction ID. It loops over all transaction in all blocks and once it find, it returns it, unless the
+// chain has a transaction index.
+// The error wraps ErrTxNotFound when no block of the main chain holds it.
+func (chain *BlockChain) FindTx(ID []byte) (*Transaction, error) {
+	tx, _, err := chain.FindTxBlock(ID)
+
+	return tx, err
 }
 
-// FindTx by transaction ID. It loops over all transaction in all blocks and once it find, it returns it.
-func (chain *BlockChain) FindTx(ID []byte) *Transaction {
+// FindTxBlock is FindTx also returning the block holding the transaction. It uses the transaction index when the
+// chain has one.
+func (chain *BlockChain) FindTxBlock(ID []byte) (*Transaction, *Block, error) {
+	tx, block, indexed, err := chain.lookupTx(ID)
+	if indexed || err != nil {
+		return tx, block, err
+	}
+
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Equal(tx.ID, ID) {
-				return tx
+				return tx, block, nil
 			}
 		}
 
 		if len(block.PrevHash) == 0 {
-			return nil
+			return nil, nil, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
 		}
 	}
 }
 
 // SignTx is signing a transaction using the previous transaction, and private key get signed.
-func (chain *BlockChain) SignTx(transaction *Transaction, privKey *ecdsa.PrivateKey) {
-	var prevTxs map[string]*Transaction
+func (chain *BlockChain) SignTx(transaction *Transaction, privKey *ecdsa.PrivateKey) error {
+	prevTxs := make(map[string]*Transaction)
 	for _, in := range transaction.Inputs {
-		if prevTx := chain.FindTx(in.ID); prevTx != nil {
-			prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		prevTx, err := chain.FindTx(in.ID)
+		if err != nil {
+			return err
 		}
+		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
-	transaction.Sign(privKey, prevTxs)
+	return transaction.Sign(privKey, prevTxs)
 }
 
-func (chain *BlockChain) VerifyTx(tx *Transaction) bool {
-	var prevTxs map[string]*Transaction
+// VerifyTx runs the scripts of a transaction against the outputs it spends. A transaction spending outputs that are
+// not on the main chain does not verify, the error is only set when the chain could not be read.
+func (chain *BlockChain) VerifyTx(tx *Transaction) (bool, error) {
+	if tx.IsCoinbase() {
+		return true, nil
+	}
+
+	prevTxs := make(map[string]*Transaction)
 	for _, in := range tx.Inputs {
-		if prevTx := chain.FindTx(in.ID); prevTx != nil {
-			prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		prevTx, err := chain.FindTx(in.ID)
+		if errors.Is(err, ErrTxNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
 		}
+		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
 
-	return tx.Verify(prevTxs)
+	return tx.Verify(prevTxs), nil
 }
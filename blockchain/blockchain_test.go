@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// newTestChain creates a regtest chain whose genesis block pays w. The chain is kept in memory unless opts has a
+// data directory or a store of its own.
+func newTestChain(t testing.TB, w *wallet.Wallet, opts Options) *BlockChain {
+	t.Helper()
+
+	opts.Network = "regtest"
+	if opts.Store == nil && opts.DataDir == "" {
+		opts.Store = storage.NewMemoryStore()
+	}
+	if opts.DataDir != "" {
+		if err := os.MkdirAll(opts.ChainDir(), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		badgerOpts := badger.DefaultOptions("")
+		badgerOpts.Logger = nil
+		opts.Badger = &badgerOpts
+	}
+
+	chain, err := InitBlockChain(string(w.Address()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	return chain
+}
+
+// mineOn mines a block with the transactions on top of parent, adding a coinbase paying w first. The block is not
+// imported.
+func mineOn(t testing.TB, chain *BlockChain, parent *Block, w *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+
+	return mineAt(t, chain, parent, parent.Timestamp+1, w, txs...)
+}
+
+// mineAt is mineOn with the timestamp of the block.
+func mineAt(t testing.TB, chain *BlockChain, parent *Block, timestamp int64, w *wallet.Wallet,
+	txs ...*Transaction) *Block {
+	t.Helper()
+
+	bits, err := chain.NextBits(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := BlockHeader{
+		PrevHash:  parent.Hash,
+		Timestamp: timestamp,
+		Bits:      bits,
+		Height:    parent.Height + 1,
+	}
+
+	coinbase := CoinBaseTx(string(w.Address()), "", chain.params().GetBlockSubsidy(header.Height))
+	block, err := CreateBlock(header, append([]*Transaction{coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// tip returns the tip block of the main chain.
+func tip(t testing.TB, chain *BlockChain) *Block {
+	t.Helper()
+
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// addBlock mines a block with the transactions on the tip and connects it.
+func addBlock(t testing.TB, chain *BlockChain, w *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+
+	block := mineOn(t, chain, tip(t, chain), w, txs...)
+	if err := chain.ConnectBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// pay spends output out of prev, locked to from, to the address of to. The difference goes to fees.
+func pay(t *testing.T, from *wallet.Wallet, prev *Transaction, out int, to *wallet.Wallet, amount int) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: prev.ID, Out: out, PubKey: from.PublicKey}},
+		Outputs: []TxOutput{*NewTxOutput(amount, string(to.Address()))},
+	}
+	tx.SetID()
+
+	err := tx.Sign(&from.PrivateKey, map[string]*Transaction{fmt.Sprintf("%x", prev.ID): prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
@@ -0,0 +1,191 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// ErrUnknownCoinSelector is returned for a coin selection strategy name without an implementation.
+var ErrUnknownCoinSelector = errors.New("unknown coin selection strategy")
+
+// bnbMaxTries bounds the number of branches BranchAndBound explores.
+const bnbMaxTries = 100000
+
+// CoinSelector picks the outputs a new transaction spends.
+type CoinSelector interface {
+	// Select returns outputs among candidates worth at least target, or every candidate when they are worth less
+	// altogether. The candidates are not modified.
+	Select(candidates []UnspentOutput, target int) []UnspentOutput
+}
+
+// NewCoinSelector returns the strategy with the given name: largest, smallest, bnb or random.
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case "largest":
+		return LargestFirst{}, nil
+	case "smallest":
+		return SmallestFirst{}, nil
+	case "bnb":
+		return BranchAndBound{}, nil
+	case "random":
+		return RandomImprove{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownCoinSelector, name)
+}
+
+// LargestFirst spends the largest outputs first, which keeps the number of inputs low.
+type LargestFirst struct{}
+
+func (LargestFirst) Select(candidates []UnspentOutput, target int) []UnspentOutput {
+	sorted := sortedByValue(candidates)
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+
+	return accumulate(sorted, target)
+}
+
+// SmallestFirst spends the smallest outputs first, which consolidates dust into the change.
+type SmallestFirst struct{}
+
+func (SmallestFirst) Select(candidates []UnspentOutput, target int) []UnspentOutput {
+	return accumulate(sortedByValue(candidates), target)
+}
+
+// BranchAndBound searches for a set of outputs worth between target and target plus Tolerance, so the transaction
+// needs no change output. The set with the least excess found within bnbMaxTries branches wins. Without a match it
+// falls back to Fallback, LargestFirst when nil.
+type BranchAndBound struct {
+	Tolerance int
+	Fallback  CoinSelector
+}
+
+func (s BranchAndBound) Select(candidates []UnspentOutput, target int) []UnspentOutput {
+	sorted := sortedByValue(candidates)
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+
+	// remaining[i] is the value of the outputs from i on, the most the unexplored branch can still add.
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	var best []int
+	bestExcess := -1
+	var picked []int
+	tries := 0
+
+	var search func(i, value int)
+	search = func(i, value int) {
+		if tries >= bnbMaxTries || bestExcess == 0 {
+			return
+		}
+		tries++
+
+		if value > target+s.Tolerance || value+remaining[i] < target {
+			return
+		}
+		if value >= target {
+			if excess := value - target; bestExcess < 0 || excess < bestExcess {
+				best = append(best[:0], picked...)
+				bestExcess = excess
+			}
+			return
+		}
+		if i == len(sorted) {
+			return
+		}
+
+		picked = append(picked, i)
+		search(i+1, value+sorted[i].Output.Value)
+		picked = picked[:len(picked)-1]
+
+		search(i+1, value)
+	}
+	search(0, 0)
+
+	if bestExcess < 0 {
+		fallback := s.Fallback
+		if fallback == nil {
+			fallback = LargestFirst{}
+		}
+
+		return fallback.Select(candidates, target)
+	}
+
+	selected := make([]UnspentOutput, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, sorted[i])
+	}
+
+	return selected
+}
+
+// RandomImprove picks random outputs until target is reached, then keeps adding random outputs while they bring
+// the selection closer to twice the target without exceeding three times the target. The change it leaves is about
+// the size of the payment, which keeps outputs useful for later payments. Rand is the math/rand source when nil.
+type RandomImprove struct {
+	Rand *rand.Rand
+}
+
+func (s RandomImprove) Select(candidates []UnspentOutput, target int) []UnspentOutput {
+	shuffled := append([]UnspentOutput(nil), candidates...)
+	shuffle := rand.Shuffle
+	if s.Rand != nil {
+		shuffle = s.Rand.Shuffle
+	}
+	shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	selected := append([]UnspentOutput(nil), accumulate(shuffled, target)...)
+	rest := shuffled[len(selected):]
+
+	value := 0
+	for _, out := range selected {
+		value += out.Output.Value
+	}
+
+	ideal, limit := 2*target, 3*target
+	for _, out := range rest {
+		improved := value + out.Output.Value
+		if improved > limit || abs(ideal-improved) >= abs(ideal-value) {
+			continue
+		}
+		selected = append(selected, out)
+		value = improved
+	}
+
+	return selected
+}
+
+func sortedByValue(candidates []UnspentOutput) []UnspentOutput {
+	sorted := append([]UnspentOutput(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value < sorted[j].Output.Value })
+
+	return sorted
+}
+
+// accumulate takes outputs in order until they are worth target.
+func accumulate(outputs []UnspentOutput, target int) []UnspentOutput {
+	value := 0
+	for i, out := range outputs {
+		if value >= target {
+			return outputs[:i]
+		}
+		value += out.Output.Value
+	}
+
+	return outputs
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomCandidates returns up to 30 outputs spread over a few transactions, with values repeating often.
+func randomCandidates(r *rand.Rand) []UnspentOutput {
+	var candidates []UnspentOutput
+
+	for tx := r.Intn(6); tx > 0; tx-- {
+		txID := make([]byte, 32)
+		r.Read(txID)
+
+		outputs := 1 + r.Intn(6)
+		for index := 0; index < outputs; index++ {
+			value := 1 + r.Intn(100)
+			if r.Intn(4) == 0 {
+				value *= 1 + r.Intn(50)
+			}
+			candidates = append(candidates, UnspentOutput{txID, index, TxOutput{Value: value}})
+		}
+	}
+
+	return candidates
+}
+
+func TestCoinSelectorsProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	selectors := []struct {
+		name     string
+		selector func() CoinSelector
+	}{
+		{"largest", func() CoinSelector { return LargestFirst{} }},
+		{"smallest", func() CoinSelector { return SmallestFirst{} }},
+		{"bnb", func() CoinSelector { return BranchAndBound{} }},
+		{"bnb with tolerance", func() CoinSelector { return BranchAndBound{Tolerance: r.Intn(20)} }},
+		{"bnb falling back to smallest", func() CoinSelector { return BranchAndBound{Fallback: SmallestFirst{}} }},
+		{"random", func() CoinSelector { return RandomImprove{Rand: rand.New(rand.NewSource(r.Int63()))} }},
+	}
+
+	for _, s := range selectors {
+		t.Run(s.name, func(t *testing.T) {
+			for i := 0; i < 2000; i++ {
+				candidates := randomCandidates(r)
+				total := 0
+				for _, c := range candidates {
+					total += c.Output.Value
+				}
+				target := r.Intn(total + total/5 + 1)
+
+				unchanged := append([]UnspentOutput(nil), candidates...)
+				selected := s.selector().Select(candidates, target)
+
+				if err := checkSelection(candidates, selected, target, total); err != nil {
+					t.Fatalf("target %d of %v: %v", target, candidates, err)
+				}
+				if !reflect.DeepEqual(candidates, unchanged) {
+					t.Fatalf("candidates modified: %v, were %v", candidates, unchanged)
+				}
+			}
+		})
+	}
+}
+
+// checkSelection checks that every selected output is a distinct candidate and that the selection reaches target,
+// or holds every candidate when they are worth less than target altogether.
+func checkSelection(candidates, selected []UnspentOutput, target, total int) error {
+	available := make(map[string]TxOutput)
+	for _, c := range candidates {
+		available[outpoint(c.TxID, c.Index)] = c.Output
+	}
+
+	value := 0
+	seen := make(map[string]bool)
+	for _, s := range selected {
+		op := outpoint(s.TxID, s.Index)
+		if seen[op] {
+			return fmt.Errorf("%s selected twice", op)
+		}
+		seen[op] = true
+
+		out, ok := available[op]
+		if !ok {
+			return fmt.Errorf("%s is not a candidate", op)
+		}
+		if !reflect.DeepEqual(out, s.Output) {
+			return fmt.Errorf("%s holds %v, the candidate %v", op, s.Output, out)
+		}
+		value += s.Output.Value
+	}
+
+	if total < target {
+		if len(selected) != len(candidates) {
+			return fmt.Errorf("%d of %d candidates selected while they are short of the target",
+				len(selected), len(candidates))
+		}
+		return nil
+	}
+
+	if value < target {
+		return fmt.Errorf("selected %d", value)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+var errCrash = errors.New("crash")
+
+// crashStore fails the failAt-th write, counting the writes of every transaction from when it was armed, and every
+// write after it, as if the process died there.
+type crashStore struct {
+	storage.Store
+	failAt int
+	writes int
+}
+
+func (s *crashStore) arm(failAt int) {
+	s.failAt = failAt
+	s.writes = 0
+}
+
+func (s *crashStore) crash() bool {
+	if s.failAt == 0 {
+		return false
+	}
+	s.writes++
+
+	return s.writes >= s.failAt
+}
+
+func (s *crashStore) Put(key, value []byte) error {
+	if s.crash() {
+		return errCrash
+	}
+
+	return s.Store.Put(key, value)
+}
+
+func (s *crashStore) Delete(key []byte) error {
+	if s.crash() {
+		return errCrash
+	}
+
+	return s.Store.Delete(key)
+}
+
+func (s *crashStore) Update(f func(txn storage.Txn) error) error {
+	return s.Store.Update(func(txn storage.Txn) error {
+		return f(&crashTxn{txn, s})
+	})
+}
+
+type crashTxn struct {
+	storage.Txn
+	store *crashStore
+}
+
+func (t *crashTxn) Put(key, value []byte) error {
+	if t.store.crash() {
+		return errCrash
+	}
+
+	return t.Txn.Put(key, value)
+}
+
+func (t *crashTxn) Delete(key []byte) error {
+	if t.store.crash() {
+		return errCrash
+	}
+
+	return t.Txn.Delete(key)
+}
+
+// chainState dumps the tip, the UTXO set, the undo records and every index of the chain. The blocks and their work
+// are left out, an imported block is kept as a side branch whether its branch becomes the main chain or not.
+func chainState(t *testing.T, chain *BlockChain) map[string]string {
+	t.Helper()
+
+	state := map[string]string{}
+	for _, prefix := range [][]byte{[]byte("lh"), utxoPrefix, undoPrefix, []byte("height-"), txIndexPrefix,
+		addrIndexPrefix} {
+		state[string(prefix)] = dumpPrefix(t, chain, prefix)
+	}
+
+	return state
+}
+
+func checkState(t *testing.T, chain *BlockChain, want map[string]string, lastHash []byte, failAt int) {
+	t.Helper()
+
+	if !bytes.Equal(chain.LastHash, lastHash) {
+		t.Fatalf("crash at write %d: tip %x, want %x", failAt, chain.LastHash, lastHash)
+	}
+	for prefix, dump := range chainState(t, chain) {
+		if dump != want[prefix] {
+			t.Fatalf("crash at write %d: %s keys changed:\n%s\nwant:\n%s", failAt, prefix, dump, want[prefix])
+		}
+	}
+}
+
+// cloneStore copies every key of a store into a new memory store.
+func cloneStore(t *testing.T, store storage.Store) *storage.MemoryStore {
+	t.Helper()
+
+	clone := storage.NewMemoryStore()
+	err := store.Iterate(nil, func(key, value []byte) error {
+		return clone.Put(key, value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clone
+}
+
+func TestConnectBlockCrash(t *testing.T) {
+	w := wallet.MakeWallet()
+	store := &crashStore{Store: storage.NewMemoryStore()}
+	chain := newTestChain(t, w, Options{Store: store, TxIndex: true, AddrIndex: true})
+	genesis := tip(t, chain)
+
+	block := mineOn(t, chain, genesis, w, pay(t, w, genesis.Transactions[0], 0, wallet.MakeWallet(), 15))
+	before := chainState(t, chain)
+	work := dumpPrefix(t, chain, workPrefix)
+
+	failAt := 1
+	for ; ; failAt++ {
+		store.arm(failAt)
+		err := chain.ConnectBlock(block)
+		store.arm(0)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errCrash) {
+			t.Fatalf("ConnectBlock = %v, want %v", err, errCrash)
+		}
+		checkState(t, chain, before, genesis.Hash, failAt)
+		if chain.HasBlock(block.Hash) || dumpPrefix(t, chain, workPrefix) != work {
+			t.Fatalf("crash at write %d: block stored", failAt)
+		}
+	}
+	if failAt < 8 {
+		t.Errorf("connecting took %d writes, the crash missed some of them", failAt-1)
+	}
+
+	connected := chainState(t, chain)
+	if connected["lh"] == before["lh"] || connected[string(utxoPrefix)] == before[string(utxoPrefix)] {
+		t.Fatal("the block was not connected")
+	}
+
+	for failAt = 1; ; failAt++ {
+		store.arm(failAt)
+		_, err := chain.DisconnectTip()
+		store.arm(0)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errCrash) {
+			t.Fatalf("DisconnectTip = %v, want %v", err, errCrash)
+		}
+		checkState(t, chain, connected, block.Hash, failAt)
+	}
+
+	if err := chain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReorganizeCrash(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{TxIndex: true, AddrIndex: true})
+	genesis := tip(t, chain)
+	coinbase := genesis.Transactions[0]
+
+	addBlock(t, chain, w, pay(t, w, coinbase, 0, wallet.MakeWallet(), 20))
+	a2 := addBlock(t, chain, w)
+
+	b1 := mineOn(t, chain, genesis, w, pay(t, w, coinbase, 0, wallet.MakeWallet(), 15))
+	b2 := mineOn(t, chain, b1, w)
+	b3 := mineOn(t, chain, b2, w)
+	importBlocks(t, chain, b1, b2)
+	before := chainState(t, chain)
+
+	reorganized := func(failAt int) (*BlockChain, error) {
+		store := &crashStore{Store: cloneStore(t, chain.Database)}
+		clone, err := ContinueBlockChain(Options{Network: "regtest", Store: store, TxIndex: true, AddrIndex: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store.arm(failAt)
+		_, err = clone.ImportBlock(b3)
+		store.arm(0)
+
+		return clone, err
+	}
+
+	want, err := reorganized(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := chainState(t, want)
+
+	failAt := 1
+	for ; ; failAt++ {
+		clone, err := reorganized(failAt)
+		if err == nil {
+			checkState(t, clone, after, b3.Hash, failAt)
+			break
+		}
+		if !errors.Is(err, errCrash) {
+			t.Fatalf("ImportBlock = %v, want %v", err, errCrash)
+		}
+
+		// the process restarts on what was written before the crash.
+		store := clone.Database.(*crashStore).Store
+		reopened, err := ContinueBlockChain(Options{Network: "regtest", Store: store, TxIndex: true, AddrIndex: true})
+		if err != nil {
+			t.Fatalf("crash at write %d: %v", failAt, err)
+		}
+		if reopened.HasBlock(b3.Hash) {
+			checkState(t, reopened, after, b3.Hash, failAt)
+		} else {
+			checkState(t, reopened, before, a2.Hash, failAt)
+		}
+		if err := reopened.Verify(); err != nil {
+			t.Fatalf("crash at write %d: %v", failAt, err)
+		}
+
+		importBlocks(t, reopened, b3)
+		checkState(t, reopened, after, b3.Hash, failAt)
+	}
+	if failAt < 20 {
+		t.Errorf("reorganizing took %d writes, the crash missed some of them", failAt-1)
+	}
+}
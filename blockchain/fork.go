@@ -0,0 +1,508 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+var workPrefix = []byte("work-")
+
+// ErrDisconnectGenesis is returned when disconnecting the tip would leave the chain empty.
+var ErrDisconnectGenesis = errors.New("the genesis block cannot be disconnected")
+
+// ErrNotTip is returned by ConnectBlock for a block whose parent is not the tip.
+var ErrNotTip = errors.New("block does not extend the tip")
+
+// TipChange tells how the main chain moved when a block was imported.
+type TipChange struct {
+	Connected    []*Block // blocks added to the main chain, oldest first
+	Disconnected []*Block // blocks removed from the main chain, newest first
+}
+
+// BlockWork is the expected number of hashes needed to find a block with the given target bits: 2^256 / (target+1).
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+func workKey(blockHash []byte) []byte {
+	key := make([]byte, 0, len(workPrefix)+len(blockHash))
+	key = append(key, workPrefix...)
+
+	return append(key, blockHash...)
+}
+
+// setCumulativeWork records the work of the block and all its ancestors.
+func setCumulativeWork(txn storage.Txn, blockHash []byte, work *big.Int) error {
+	return txn.Put(workKey(blockHash), work.Bytes())
+}
+
+// CumulativeWork returns the total work of the chain ending with the block. Blocks stored before the work was
+// recorded get it computed from their ancestors.
+func (chain *BlockChain) CumulativeWork(blockHash []byte) (*big.Int, error) {
+	v, err := chain.Database.Get(workKey(blockHash))
+	if err == nil {
+		return new(big.Int).SetBytes(v), nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	work := BlockWork(block.Bits)
+	if len(block.PrevHash) == 0 {
+		return work, nil
+	}
+
+	parentWork, err := chain.CumulativeWork(block.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return work.Add(work, parentWork), nil
+}
+
+// ImportBlock stores a block received from another node and applies the fork choice rule: the main chain is the one
+// with the most cumulative work. The block must pass CheckBlockHeader, which requires the parent to be known
+// already, and CheckBlockSanity. A block extending the tip is connected right away. A block on a side branch is
+// kept, and when its branch becomes heavier than the main chain the blocks of the main chain are disconnected back to
+// the fork point and the ones of the branch connected, keeping the UTXO set in step. Transactions are validated
+// against the UTXO set as blocks get connected, an invalid block leaves the main chain as it was and is forgotten
+// along with the blocks of the branch after it, so a valid block with the same hash can still be imported.
+func (chain *BlockChain) ImportBlock(block *Block) (TipChange, error) {
+	if chain.HasBlock(block.Hash) {
+		return TipChange{}, nil
+	}
+
+	if err := chain.CheckBlockHeader(block, time.Now()); err != nil {
+		return TipChange{}, err
+	}
+	if err := CheckBlockSanity(block); err != nil {
+		return TipChange{}, err
+	}
+
+	parentWork, err := chain.CumulativeWork(block.PrevHash)
+	if err != nil && len(block.PrevHash) != 0 {
+		return TipChange{}, err
+	}
+	if parentWork == nil {
+		parentWork = big.NewInt(0)
+	}
+	work := new(big.Int).Add(parentWork, BlockWork(block.Bits))
+
+	tipWork, err := chain.CumulativeWork(chain.LastHash)
+	if err != nil {
+		return TipChange{}, err
+	}
+
+	if bytes.Equal(block.PrevHash, chain.LastHash) {
+		if err := chain.connectTip(block, work); err != nil {
+			return TipChange{}, err
+		}
+
+		return TipChange{Connected: []*Block{block}}, nil
+	}
+
+	err = chain.Database.Update(func(txn storage.Txn) error {
+		if err := txn.Put(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+
+		return setCumulativeWork(txn, block.Hash, work)
+	})
+	if err != nil {
+		return TipChange{}, err
+	}
+
+	if work.Cmp(tipWork) <= 0 {
+		return TipChange{}, nil
+	}
+
+	return chain.reorganize(block)
+}
+
+// ConnectBlock validates a block extending the tip and connects it: the block, its work, the new tip, the UTXO set
+// changes and the indexes are written in one transaction, so a failure leaves the chain as it was.
+func (chain *BlockChain) ConnectBlock(block *Block) error {
+	if !bytes.Equal(block.PrevHash, chain.LastHash) {
+		return fmt.Errorf("%w: parent %x", ErrNotTip, block.PrevHash)
+	}
+
+	if err := chain.CheckBlockHeader(block, time.Now()); err != nil {
+		return err
+	}
+
+	parentWork, err := chain.CumulativeWork(block.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	return chain.connectTip(block, new(big.Int).Add(parentWork, BlockWork(block.Bits)))
+}
+
+// connectTip validates the transactions of a block extending the tip against the UTXO set, then stores it, moves
+// the tip and updates the UTXO set and the indexes in one transaction.
+func (chain *BlockChain) connectTip(block *Block, work *big.Int) error {
+	coins := &chainCoins{
+		UTXOSet: &UTXOSet{chain},
+		added:   make(map[string]coin),
+		spent:   make(map[string]bool),
+	}
+	medianTime, err := chain.parentMedianTime(block)
+	if err != nil {
+		return err
+	}
+	if err := verifyBlockTransactions(block, coins, chain.params(), medianTime); err != nil {
+		return err
+	}
+
+	err = chain.Database.Update(func(txn storage.Txn) error {
+		if err := txn.Put(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := setCumulativeWork(txn, block.Hash, work); err != nil {
+			return err
+		}
+		if err := setHeight(txn, block.Height, block.Hash); err != nil {
+			return err
+		}
+		if err := indexTxs(txn, block); err != nil {
+			return err
+		}
+		// the address index reads the outputs the block spends, before they leave the UTXO set.
+		if err := indexAddresses(txn, block); err != nil {
+			return err
+		}
+		if err := updateUTXO(txn, block); err != nil {
+			return err
+		}
+
+		return txn.Put([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.Hash
+
+	return nil
+}
+
+// DisconnectTip removes the tip block from the main chain: its UTXO set changes are reverted, the indexes updated
+// and the tip moves back to its parent, all in one transaction. The block itself stays stored as a side branch. The
+// genesis block cannot be disconnected.
+func (chain *BlockChain) DisconnectTip() (*Block, error) {
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.PrevHash) == 0 {
+		return nil, ErrDisconnectGenesis
+	}
+
+	UTXOSet := UTXOSet{chain}
+	undo, err := UTXOSet.blockUndo(&block)
+	if err != nil {
+		return nil, err
+	}
+
+	err = chain.Database.Update(func(txn storage.Txn) error {
+		if err := revertUTXO(txn, &block, undo); err != nil {
+			return err
+		}
+		if err := txn.Delete(heightKey(block.Height)); err != nil {
+			return err
+		}
+		if err := unindexTxs(txn, &block); err != nil {
+			return err
+		}
+		// the address index reads the outputs the block spent, back in the UTXO set.
+		if err := unindexAddresses(txn, &block); err != nil {
+			return err
+		}
+
+		return txn.Put([]byte("lh"), block.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	chain.LastHash = block.PrevHash
+
+	return &block, nil
+}
+
+// reorganize switches the main chain to the branch ending with newTip. When a block of the branch turns out to be
+// invalid, or a block fails to be disconnected or connected, the original main chain is restored and the error
+// returned.
+func (chain *BlockChain) reorganize(newTip *Block) (TipChange, error) {
+	detach, attach, err := chain.findFork(chain.LastHash, newTip)
+	if err != nil {
+		return TipChange{}, err
+	}
+
+	for i := range detach {
+		if _, err := chain.DisconnectTip(); err != nil {
+			if restoreErr := chain.restore(nil, detach[:i]); restoreErr != nil {
+				return TipChange{}, fmt.Errorf("%v, restoring the main chain: %w", err, restoreErr)
+			}
+
+			return TipChange{}, err
+		}
+	}
+
+	for i, block := range attach {
+		work, err := chain.CumulativeWork(block.Hash)
+		if err != nil {
+			return TipChange{}, err
+		}
+
+		if err := chain.connectTip(block, work); err != nil {
+			if restoreErr := chain.restore(attach[:i], detach); restoreErr != nil {
+				return TipChange{}, fmt.Errorf("%v, restoring the main chain: %w", err, restoreErr)
+			}
+
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				if forgetErr := chain.forget(attach[i:]); forgetErr != nil {
+					return TipChange{}, fmt.Errorf("%v, forgetting the branch: %w", err, forgetErr)
+				}
+			}
+
+			return TipChange{}, err
+		}
+	}
+
+	return TipChange{Connected: attach, Disconnected: detach}, nil
+}
+
+// restore undoes a failed reorganization: the blocks connected from the branch are disconnected, newest first,
+// and the blocks of the former main chain connected again, oldest first.
+func (chain *BlockChain) restore(attached, detached []*Block) error {
+	for range attached {
+		if _, err := chain.DisconnectTip(); err != nil {
+			return err
+		}
+	}
+
+	for i := len(detached) - 1; i >= 0; i-- {
+		work, err := chain.CumulativeWork(detached[i].Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := chain.connectTip(detached[i], work); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// activateBestChain applies the fork choice rule to the stored blocks: while a stored block has more cumulative work
+// than the tip, the main chain is reorganized to it. A reorganization is not written at once, so a process dying in
+// the middle of one leaves the tip on the way from the former main chain to the heavier branch, ContinueBlockChain
+// resumes it here. Branches that turn out invalid are forgotten and the next heaviest one tried.
+func (chain *BlockChain) activateBestChain() error {
+	for {
+		tipWork, err := chain.CumulativeWork(chain.LastHash)
+		if err != nil {
+			return err
+		}
+
+		best, bestWork, err := chain.heaviestBlock()
+		if err != nil {
+			return err
+		}
+		if best == nil || bestWork.Cmp(tipWork) <= 0 {
+			return nil
+		}
+
+		block, err := chain.GetBlock(best)
+		if err == nil {
+			_, err = chain.reorganize(&block)
+		}
+
+		var invalid *ValidationError
+		switch {
+		case errors.Is(err, ErrBlockNotFound):
+			// a block whose branch was forgotten, or the work of a block that is gone.
+			if err := chain.forget([]*Block{{Hash: best}}); err != nil {
+				return err
+			}
+		case errors.As(err, &invalid):
+			// reorganize forgot the invalid blocks.
+		case err != nil:
+			return err
+		}
+	}
+}
+
+// heaviestBlock returns the hash of the stored block with the most cumulative work and its work, nil when no work is
+// recorded.
+func (chain *BlockChain) heaviestBlock() ([]byte, *big.Int, error) {
+	var best []byte
+	bestWork := big.NewInt(0)
+
+	err := chain.Database.Iterate(workPrefix, func(key, value []byte) error {
+		if work := new(big.Int).SetBytes(value); best == nil || work.Cmp(bestWork) > 0 {
+			best = append([]byte{}, key[len(workPrefix):]...)
+			bestWork = work
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return best, bestWork, nil
+}
+
+// forget deletes blocks of a side branch along with their cumulative work.
+func (chain *BlockChain) forget(blocks []*Block) error {
+	return chain.Database.Update(func(txn storage.Txn) error {
+		for _, block := range blocks {
+			if err := txn.Delete(block.Hash); err != nil {
+				return err
+			}
+			if err := txn.Delete(workKey(block.Hash)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// findFork walks back from the current tip and from the new tip until both branches meet. It returns the blocks to
+// disconnect, newest first, and the blocks to connect, oldest first.
+func (chain *BlockChain) findFork(tipHash []byte, newTip *Block) ([]*Block, []*Block, error) {
+	var detach, attach []*Block
+
+	tip, err := chain.GetBlock(tipHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldBranch := &tip
+	newBranch := newTip
+
+	parent := func(block *Block) (*Block, error) {
+		if len(block.PrevHash) == 0 {
+			return nil, fmt.Errorf("block %x and %x do not share a genesis", tipHash, newTip.Hash)
+		}
+		prev, err := chain.GetBlock(block.PrevHash)
+
+		return &prev, err
+	}
+
+	for !bytes.Equal(oldBranch.Hash, newBranch.Hash) {
+		if oldBranch.Height >= newBranch.Height {
+			detach = append(detach, oldBranch)
+			if oldBranch, err = parent(oldBranch); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			attach = append([]*Block{newBranch}, attach...)
+			if newBranch, err = parent(newBranch); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// chainCoins is the coin view of a block extending the tip: the UTXO set plus the outputs of the transactions of the
+// block met so far.
+type chainCoins struct {
+	UTXOSet *UTXOSet
+	added   map[string]coin
+	spent   map[string]bool
+}
+
+func (c *chainCoins) spend(in TxInput) (coin, error) {
+	op := outpoint(in.ID, in.Out)
+	if c.spent[op] {
+		return coin{}, fmt.Errorf("%w: %s", ErrSpentInput, op)
+	}
+
+	prev, ok := c.added[hex.EncodeToString(in.ID)]
+	if !ok {
+		var err error
+		prev, err = c.UTXOSet.Blockchain.confirmedCoin(in.ID)
+		if errors.Is(err, ErrTxNotFound) {
+			return coin{}, fmt.Errorf("%w: %s", ErrMissingInput, op)
+		}
+		if err != nil {
+			return coin{}, err
+		}
+
+		_, ok, err := c.UTXOSet.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return coin{}, err
+		}
+		if !ok {
+			return coin{}, fmt.Errorf("%w: %s", ErrSpentInput, op)
+		}
+	}
+
+	if in.Out < 0 || in.Out >= len(prev.tx.Outputs) {
+		return coin{}, fmt.Errorf("%w: %s", ErrMissingInput, op)
+	}
+	c.spent[op] = true
+
+	return prev, nil
+}
+
+func (c *chainCoins) add(tx *Transaction, height int, medianTime int64) {
+	c.added[hex.EncodeToString(tx.ID)] = coin{tx, height, medianTime}
+}
+
+func (c *chainCoins) unspent(txID []byte) (bool, error) {
+	outIdxs := make(map[int]bool)
+
+	if prev, ok := c.added[hex.EncodeToString(txID)]; ok {
+		for outIdx := range prev.tx.Outputs {
+			outIdxs[outIdx] = true
+		}
+	} else {
+		v, err := c.UTXOSet.Blockchain.Database.Get(utxoKey(txID))
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return false, err
+		}
+		for outIdx := range outs.Outputs {
+			outIdxs[outIdx] = true
+		}
+	}
+
+	for outIdx := range outIdxs {
+		if !c.spent[outpoint(txID, outIdx)] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
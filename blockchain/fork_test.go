@@ -0,0 +1,356 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// dumpPrefix lists the keys starting with prefix and their values. The outputs of the UTXO set are decoded, gob
+// encodes their map in random order.
+func dumpPrefix(t *testing.T, chain *BlockChain, prefix []byte) string {
+	t.Helper()
+
+	var dump bytes.Buffer
+	err := chain.Database.Iterate(prefix, func(key, value []byte) error {
+		if !bytes.HasPrefix(key, utxoPrefix) {
+			fmt.Fprintf(&dump, "%x=%x\n", key, value)
+			return nil
+		}
+
+		outs, err := DeserializeOutputs(value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&dump, "%x=%v\n", key, outs.Outputs)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dump.String()
+}
+
+func totalValue(t *testing.T, chain *BlockChain) int {
+	t.Helper()
+
+	total, err := (&UTXOSet{chain}).TotalValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return total
+}
+
+func importBlocks(t *testing.T, chain *BlockChain, blocks ...*Block) TipChange {
+	t.Helper()
+
+	var change TipChange
+	for _, block := range blocks {
+		var err error
+		if change, err = chain.ImportBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return change
+}
+
+func hashes(blocks []*Block) []string {
+	var list []string
+	for _, block := range blocks {
+		list = append(list, fmt.Sprintf("%x", block.Hash))
+	}
+
+	return list
+}
+
+func TestReorganizeToHeavierBranch(t *testing.T) {
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	bob := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{DataDir: t.TempDir()})
+	genesis := tip(t, chain)
+	coinbase := genesis.Transactions[0]
+
+	toAlice := pay(t, w, coinbase, 0, alice, 20)
+	a1 := addBlock(t, chain, w, toAlice)
+	a2 := addBlock(t, chain, w)
+
+	if total := totalValue(t, chain); total != 60 {
+		t.Fatalf("UTXO set holds %d before the reorganization, want 60", total)
+	}
+
+	// the side branch spends the genesis coinbase to bob instead, leaving a fee of 5.
+	toBob := pay(t, w, coinbase, 0, bob, 15)
+	b1 := mineOn(t, chain, genesis, w, toBob)
+	b2 := mineOn(t, chain, b1, w)
+	b3 := mineOn(t, chain, b2, w)
+
+	// as heavy as the main chain is not enough.
+	if change := importBlocks(t, chain, b1, b2); len(change.Connected) != 0 || !bytes.Equal(chain.LastHash, a2.Hash) {
+		t.Fatal("a branch with as much work replaced the main chain")
+	}
+
+	change := importBlocks(t, chain, b3)
+	if got, want := hashes(change.Disconnected), hashes([]*Block{a2, a1}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("disconnected %v, want %v", got, want)
+	}
+	if got, want := hashes(change.Connected), hashes([]*Block{b1, b2, b3}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("connected %v, want %v", got, want)
+	}
+
+	if !bytes.Equal(chain.LastHash, b3.Hash) {
+		t.Fatalf("tip %x, want %x", chain.LastHash, b3.Hash)
+	}
+	for _, block := range []*Block{genesis, b1, b2, b3} {
+		hash, err := chain.GetBlockHash(block.Height)
+		if err != nil || !bytes.Equal(hash, block.Hash) {
+			t.Errorf("height %d is %x, %v, want %x", block.Height, hash, err, block.Hash)
+		}
+	}
+
+	if total := totalValue(t, chain); total != 75 {
+		t.Errorf("UTXO set holds %d after the reorganization, want 75", total)
+	}
+
+	UTXOSet := UTXOSet{chain}
+	if outs, err := UTXOSet.FindUTXO(wallet.PublicKeyHash(alice.PublicKey)); err != nil || len(outs) != 0 {
+		t.Errorf("alice keeps %v, %v from the disconnected branch", outs, err)
+	}
+	if outs, err := UTXOSet.FindUTXO(wallet.PublicKeyHash(bob.PublicKey)); err != nil || len(outs) != 1 || outs[0].Value != 15 {
+		t.Errorf("bob has %v, %v, want an output of 15", outs, err)
+	}
+	for _, tx := range []*Transaction{toAlice, a1.Transactions[0], a2.Transactions[0]} {
+		if _, found, err := UTXOSet.FindOutput(tx.ID, 0); err != nil || found {
+			t.Errorf("output of disconnected transaction %x is still unspent", tx.ID)
+		}
+	}
+
+	if err := chain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// forwardHashes walks the main chain up from height from.
+func forwardHashes(t *testing.T, chain *BlockChain, from int) []string {
+	t.Helper()
+
+	iter, err := chain.ForwardIterator(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks []*Block
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block == nil {
+			return hashes(blocks)
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func TestHeightIndexFollowsReorganization(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	a1 := addBlock(t, chain, w)
+	a2 := addBlock(t, chain, w)
+	if got, want := forwardHashes(t, chain, 0), hashes([]*Block{genesis, a1, a2}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("forward walk %v, want %v", got, want)
+	}
+	if got, want := forwardHashes(t, chain, 2), hashes([]*Block{a2}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("forward walk from 2 %v, want %v", got, want)
+	}
+
+	b1 := mineOn(t, chain, genesis, w)
+	b2 := mineOn(t, chain, b1, w)
+	b3 := mineOn(t, chain, b2, w)
+	importBlocks(t, chain, b1, b2, b3)
+
+	if got, want := forwardHashes(t, chain, 0), hashes([]*Block{genesis, b1, b2, b3}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("forward walk after the reorganization %v, want %v", got, want)
+	}
+	if height, err := chain.Height(); err != nil || height != 3 {
+		t.Errorf("height %d, %v, want 3", height, err)
+	}
+
+	// disconnecting drops the heights above the new tip.
+	for i := 0; i < 2; i++ {
+		if _, err := chain.DisconnectTip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, height := range []int{2, 3} {
+		if _, err := chain.GetBlockHash(height); !errors.Is(err, ErrBlockNotFound) {
+			t.Errorf("height %d after disconnecting: %v, want %v", height, err, ErrBlockNotFound)
+		}
+	}
+	if got, want := forwardHashes(t, chain, 0), hashes([]*Block{genesis, b1}); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("forward walk after disconnecting %v, want %v", got, want)
+	}
+}
+
+func TestReorganizeRestoresOnInvalidBlock(t *testing.T) {
+	w := wallet.MakeWallet()
+	thief := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{DataDir: t.TempDir(), TxIndex: true, AddrIndex: true})
+	genesis := tip(t, chain)
+
+	addBlock(t, chain, w, pay(t, w, genesis.Transactions[0], 0, wallet.MakeWallet(), 20))
+	a2 := addBlock(t, chain, w)
+
+	before := map[string]string{}
+	for _, prefix := range [][]byte{utxoPrefix, undoPrefix, []byte("height-"), txIndexPrefix,
+		addrIndexPrefix} {
+		before[string(prefix)] = dumpPrefix(t, chain, prefix)
+	}
+	totalBefore := totalValue(t, chain)
+
+	// the middle block of the branch spends the genesis coinbase with a key it is not locked to.
+	b1 := mineOn(t, chain, genesis, w)
+	b2 := mineOn(t, chain, b1, w, pay(t, thief, genesis.Transactions[0], 0, thief, 20))
+	b3 := mineOn(t, chain, b2, w)
+
+	importBlocks(t, chain, b1, b2)
+	_, err := chain.ImportBlock(b3)
+	if !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("ImportBlock = %v, want %v", err, ErrBadSignature)
+	}
+
+	if !bytes.Equal(chain.LastHash, a2.Hash) {
+		t.Fatalf("tip %x, want the former tip %x", chain.LastHash, a2.Hash)
+	}
+	for prefix, dump := range before {
+		if got := dumpPrefix(t, chain, []byte(prefix)); got != dump {
+			t.Errorf("%s keys changed:\n%s\nwant:\n%s", prefix, got, dump)
+		}
+	}
+	if total := totalValue(t, chain); total != totalBefore {
+		t.Errorf("UTXO set holds %d, want %d", total, totalBefore)
+	}
+
+	// the invalid block and the blocks built on it are forgotten, the valid start of the branch is kept.
+	if !chain.HasBlock(b1.Hash) {
+		t.Error("the valid block of the branch was forgotten")
+	}
+	for _, block := range []*Block{b2, b3} {
+		if chain.HasBlock(block.Hash) {
+			t.Errorf("block %d of the branch is still stored", block.Height)
+		}
+	}
+
+	if err := chain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportBlockChecksSanity(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+	a1 := addBlock(t, chain, w)
+
+	// b1 has the header and the transaction IDs of the honest block, the forged copy pays its coinbase elsewhere. The
+	// witness root of the header commits to the full coinbase.
+	b1 := mineOn(t, chain, genesis, w)
+	forged := *b1
+	forgedCoinbase := *b1.Transactions[0]
+	forgedCoinbase.Outputs = []TxOutput{*NewTxOutput(20, string(wallet.MakeWallet().Address()))}
+	forged.Transactions = []*Transaction{&forgedCoinbase}
+
+	if _, err := chain.ImportBlock(&forged); !errors.Is(err, ErrBadWitnessRoot) {
+		t.Fatalf("ImportBlock(forged) = %v, want %v", err, ErrBadWitnessRoot)
+	}
+	if chain.HasBlock(b1.Hash) {
+		t.Fatal("the forged block was stored")
+	}
+
+	b2 := mineOn(t, chain, b1, w)
+	importBlocks(t, chain, b1, b2)
+	if !bytes.Equal(chain.LastHash, b2.Hash) {
+		t.Fatalf("tip %x, want %x", chain.LastHash, b2.Hash)
+	}
+
+	// a side block whose coinbase is not the first transaction.
+	bits, err := chain.NextBits(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := BlockHeader{PrevHash: a1.Hash, Timestamp: a1.Timestamp + 1, Bits: bits, Height: a1.Height + 1}
+	spend := pay(t, w, genesis.Transactions[0], 0, w, 20)
+	coinbase := CoinBaseTx(string(w.Address()), "", chain.params().GetBlockSubsidy(header.Height))
+	late, err := CreateBlock(header, []*Transaction{spend, coinbase})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chain.ImportBlock(late); !errors.Is(err, ErrNoCoinbase) {
+		t.Fatalf("ImportBlock(late coinbase) = %v, want %v", err, ErrNoCoinbase)
+	}
+	if chain.HasBlock(late.Hash) {
+		t.Fatal("the block with a late coinbase was stored")
+	}
+}
+
+func TestContinueBlockChainSkipsInvalidBranch(t *testing.T) {
+	w := wallet.MakeWallet()
+	thief := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	a1 := addBlock(t, chain, w)
+	a2 := addBlock(t, chain, w)
+
+	// a heavier branch stored without being connected, its middle block spending a coin it cannot unlock.
+	b1 := mineOn(t, chain, genesis, w)
+	b2 := mineOn(t, chain, b1, w, pay(t, thief, genesis.Transactions[0], 0, thief, 20))
+	b3 := mineOn(t, chain, b2, w)
+	err := chain.Database.Update(func(txn storage.Txn) error {
+		for _, block := range []*Block{b1, b2, b3} {
+			if err := txn.Put(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+			work := new(big.Int).Mul(BlockWork(block.Bits), big.NewInt(int64(block.Height+1)))
+			if err := setCumulativeWork(txn, block.Hash, work); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := ContinueBlockChain(Options{Network: "regtest", Store: chain.Database})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reopened.LastHash, a2.Hash) {
+		t.Fatalf("tip %x, want %x", reopened.LastHash, a2.Hash)
+	}
+	for _, block := range []*Block{b2, b3} {
+		if reopened.HasBlock(block.Hash) {
+			t.Errorf("invalid block %d of the branch is still stored", block.Height)
+		}
+	}
+	if hash, err := reopened.GetBlockHash(1); err != nil || !bytes.Equal(hash, a1.Hash) {
+		t.Errorf("height 1 is %x, %v, want %x", hash, err, a1.Hash)
+	}
+	if err := reopened.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
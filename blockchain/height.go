@@ -0,0 +1,107 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+// heightKey is the key of the hash of the main chain block at height. The height is big endian so the keys sort
+// by height.
+func heightKey(height int) []byte {
+	return binary.BigEndian.AppendUint64([]byte("height-"), uint64(height))
+}
+
+func setHeight(txn storage.Txn, height int, blockHash []byte) error {
+	return txn.Put(heightKey(height), blockHash)
+}
+
+// indexHeights fills the height index of a chain stored before the index existed. It walks back from the tip
+// until a height already indexed with the same block, so it is cheap on an indexed chain.
+func (chain *BlockChain) indexHeights() error {
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		indexed, err := chain.GetBlockHash(block.Height)
+		if err == nil && bytes.Equal(indexed, block.Hash) {
+			return nil
+		}
+
+		if err := chain.Database.Put(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+
+		if len(block.PrevHash) == 0 {
+			return nil
+		}
+	}
+}
+
+// GetBlockHash returns the hash of the main chain block at height. The error wraps ErrBlockNotFound when the main
+// chain has no block at that height.
+func (chain *BlockChain) GetBlockHash(height int) ([]byte, error) {
+	blockHash, err := chain.Database.Get(heightKey(height))
+	if err == storage.ErrNotFound {
+		return nil, fmt.Errorf("%w: no block at height %d", ErrBlockNotFound, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return blockHash, nil
+}
+
+// GetBlockByHeight returns the main chain block at height. The error wraps ErrBlockNotFound when the main chain
+// has no block at that height.
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	blockHash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return chain.GetBlock(blockHash)
+}
+
+// Height returns the height of the tip, genesis being at height 0.
+func (chain *BlockChain) Height() (int, error) {
+	return chain.GetBestHeight()
+}
+
+// ForwardIterator walks the main chain up from a height to the tip the chain had when the iterator was created.
+type ForwardIterator struct {
+	chain  *BlockChain
+	height int
+	tip    int
+}
+
+// ForwardIterator returns an iterator starting at the main chain block at height from.
+func (chain *BlockChain) ForwardIterator(from int) (*ForwardIterator, error) {
+	tip, err := chain.Height()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ForwardIterator{chain, from, tip}, nil
+}
+
+// Next returns the current block and moves to its child. It returns a nil block past the tip.
+func (iter *ForwardIterator) Next() (*Block, error) {
+	if iter.height > iter.tip {
+		return nil, nil
+	}
+
+	block, err := iter.chain.GetBlockByHeight(iter.height)
+	if err != nil {
+		return nil, err
+	}
+	iter.height++
+
+	return &block, nil
+}
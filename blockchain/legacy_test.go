@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// openBaselineChain opens the chain of testdata/baseline-chain.txt, created by the first release.
+func openBaselineChain(t *testing.T, opts Options) *BlockChain {
+	t.Helper()
+
+	file, err := os.Open("testdata/baseline-chain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	store := storage.NewMemoryStore()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		key, err := hex.DecodeString(fields[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := hex.DecodeString(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Store = store
+	chain, err := ContinueBlockChain(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain
+}
+
+func TestBaselineChain(t *testing.T) {
+	chain := openBaselineChain(t, Options{})
+	genesis := tip(t, chain)
+
+	if genesis.Version != 0 || genesis.Bits != InitialBits || genesis.Nonce == 0 {
+		t.Fatalf("genesis header %+v, want version 0, bits %08x and its nonce", genesis.BlockHeader, InitialBits)
+	}
+	if err := chain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the UTXO set of the first release is rebuilt when the chain is opened.
+	UTXOSet := UTXOSet{chain}
+	if out, found, err := UTXOSet.FindOutput(genesis.Transactions[0].ID, 0); err != nil || !found || out.Value != 20 {
+		t.Fatalf("genesis output %+v, %t, %v, want an unspent output of 20", out, found, err)
+	}
+	if total := totalValue(t, chain); total != 20 {
+		t.Fatalf("UTXO set holds %d, want 20", total)
+	}
+
+	w := wallet.MakeWallet()
+	block := addBlock(t, chain, w)
+	if block.Version != BlockVersion {
+		t.Fatalf("block on the baseline genesis has version %d, want %d", block.Version, BlockVersion)
+	}
+	if err := chain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if total := totalValue(t, chain); total != 40 {
+		t.Fatalf("UTXO set holds %d after a block, want 40", total)
+	}
+
+	// the first release header is only accepted from a genesis block.
+	legacy := mineOn(t, chain, genesis, w)
+	legacy.Version = 0
+	var err error
+	if legacy.Nonce, legacy.Hash, err = NewProof(legacy).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.ImportBlock(legacy); !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("ImportBlock(version 0) = %v, want %v", err, ErrBadVersion)
+	}
+
+	// nor may a block go back to the header without the witness root.
+	downgraded := mineOn(t, chain, block, w)
+	downgraded.Version = 1
+	if downgraded.Nonce, downgraded.Hash, err = NewProof(downgraded).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.ImportBlock(downgraded); !errors.Is(err, ErrBadVersion) {
+		t.Fatalf("ImportBlock(version 1 after 2) = %v, want %v", err, ErrBadVersion)
+	}
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// LockTimeThreshold separates the lock times that are heights from the ones that are unix times.
+	LockTimeThreshold = 500000000
+
+	// SequenceFinal in every input of a transaction makes it final whatever its LockTime.
+	SequenceFinal = 0xffffffff
+	// SequenceLockTimeDisabled set in the sequence of an input disables its relative lock.
+	SequenceLockTimeDisabled = 1 << 31
+	// SequenceLockTimeIsSeconds set in the sequence of an input makes its relative lock a time instead of a number
+	// of blocks.
+	SequenceLockTimeIsSeconds = 1 << 22
+	// SequenceLockTimeMask selects the relative lock of the sequence, in blocks or units of
+	// 1<<SequenceLockTimeGranularity seconds.
+	SequenceLockTimeMask        = 0x0000ffff
+	SequenceLockTimeGranularity = 9
+)
+
+var (
+	ErrTxNotFinal     = errors.New("transaction is locked until a later block")
+	ErrSequenceLocked = errors.New("input is locked until its output is older")
+)
+
+// coin is an output being spent: the transaction that created it, the height of the block holding that transaction
+// and the median time past of the parent of that block.
+type coin struct {
+	tx         *Transaction
+	height     int
+	medianTime int64
+}
+
+// IsFinal reports whether the transaction can be in the block at height whose parent has the median time past
+// medianTime. A LockTime below LockTimeThreshold is the last height the transaction cannot be mined at, otherwise
+// the last time. The LockTime is ignored when every input has SequenceFinal.
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 || lockTimePassed(tx.LockTime, height, medianTime) {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lockTimePassed reports whether lockTime, a height or a time from LockTimeThreshold on, is before the block at
+// height whose parent has the median time past medianTime.
+func lockTimePassed(lockTime int64, height int, medianTime int64) bool {
+	if lockTime < LockTimeThreshold {
+		return lockTime < int64(height)
+	}
+
+	return lockTime < medianTime
+}
+
+// sequenceLocked reports whether the relative lock of the input still prevents spending c in the block at height
+// whose parent has the median time past medianTime.
+func (in *TxInput) sequenceLocked(c coin, height int, medianTime int64) bool {
+	if in.Sequence&SequenceLockTimeDisabled != 0 {
+		return false
+	}
+
+	lock := int64(in.Sequence & SequenceLockTimeMask)
+	if in.Sequence&SequenceLockTimeIsSeconds != 0 {
+		return medianTime < c.medianTime+lock<<SequenceLockTimeGranularity
+	}
+
+	return int64(height) < int64(c.height)+lock
+}
+
+// checkLocks checks the lock time of the transaction and the relative locks of its inputs, spending coins, for the
+// block at height whose parent has the median time past medianTime. Inputs without a coin are not checked.
+func checkLocks(tx *Transaction, coins []coin, height int, medianTime int64) error {
+	if !tx.IsFinal(height, medianTime) {
+		return fmt.Errorf("%w: lock time %d", ErrTxNotFinal, tx.LockTime)
+	}
+
+	for i, in := range tx.Inputs {
+		if i < len(coins) && coins[i].tx != nil && in.sequenceLocked(coins[i], height, medianTime) {
+			return fmt.Errorf("%w: input %d has sequence %#x", ErrSequenceLocked, i, in.Sequence)
+		}
+	}
+
+	return nil
+}
+
+// parentMedianTime is the median time past of the parent of a block, the time lock times are compared with in
+// the block. The genesis block has no parent, its own timestamp stands in.
+func (chain *BlockChain) parentMedianTime(block *Block) (int64, error) {
+	if len(block.PrevHash) == 0 {
+		return block.Timestamp, nil
+	}
+
+	return chain.MedianTimePast(block.PrevHash)
+}
+
+// confirmedCoin returns the transaction with the given ID on the main chain as a coin.
+func (chain *BlockChain) confirmedCoin(txID []byte) (coin, error) {
+	tx, block, err := chain.FindTxBlock(txID)
+	if err != nil {
+		return coin{}, err
+	}
+
+	medianTime, err := chain.parentMedianTime(block)
+	if err != nil {
+		return coin{}, err
+	}
+
+	return coin{tx, block.Height, medianTime}, nil
+}
+
+// CheckLocks checks the lock time of a transaction and the relative locks of its inputs against the block that
+// would extend the tip. Inputs spending outputs that are not on the main chain are left to VerifyTx.
+func (chain *BlockChain) CheckLocks(tx *Transaction) error {
+	height, medianTime, err := chain.nextBlockLocks()
+	if err != nil {
+		return err
+	}
+
+	var coins []coin
+	for _, in := range tx.Inputs {
+		c, err := chain.confirmedCoin(in.ID)
+		if err != nil && !errors.Is(err, ErrTxNotFound) {
+			return err
+		}
+		coins = append(coins, c)
+	}
+
+	return checkLocks(tx, coins, height, medianTime)
+}
+
+// nextBlockLocks returns the height of the block that would extend the tip and the median time past of the tip, which
+// the locks of its transactions are checked against.
+func (chain *BlockChain) nextBlockLocks() (int, int64, error) {
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	medianTime, err := chain.MedianTimePast(tip.Hash)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return tip.Height + 1, medianTime, nil
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func TestCheckLocksRelative(t *testing.T) {
+	// the coin was mined at height 10, after a block with a median time past of 1000.
+	c := coin{tx: &Transaction{}, height: 10, medianTime: 1000}
+
+	tests := []struct {
+		name       string
+		sequence   uint32
+		height     int
+		medianTime int64
+		locked     bool
+	}{
+		{"blocks, one block early", 5, 14, 5000, true},
+		{"blocks, at the boundary height", 5, 15, 5000, false},
+		{"blocks, zero", 0, 10, 1000, false},
+		{"blocks, bits above the mask ignored", 1<<16 | 5, 14, 5000, true},
+		{"seconds, one second early", SequenceLockTimeIsSeconds | 2, 100, 1000 + 1023, true},
+		{"seconds, 2 units of 512", SequenceLockTimeIsSeconds | 2, 100, 1000 + 1024, false},
+		{"seconds, height ignored", SequenceLockTimeIsSeconds | 2, 1000, 1000, true},
+		{"disabled", SequenceLockTimeDisabled | 100, 10, 1000, false},
+		{"disabled seconds", SequenceLockTimeDisabled | SequenceLockTimeIsSeconds | 100, 10, 1000, false},
+		{"final", SequenceFinal, 10, 1000, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{Inputs: []TxInput{{Sequence: test.sequence}}}
+			err := checkLocks(tx, []coin{c}, test.height, test.medianTime)
+			if test.locked && !errors.Is(err, ErrSequenceLocked) || !test.locked && err != nil {
+				t.Errorf("checkLocks = %v, locked %v", err, test.locked)
+			}
+		})
+	}
+}
+
+func TestConnectBlockChecksRelativeLock(t *testing.T) {
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	// the genesis coinbase may only be spent 3 blocks after genesis.
+	coinbase := genesis.Transactions[0]
+	spend := &Transaction{
+		Inputs:  []TxInput{{ID: coinbase.ID, Out: 0, PubKey: w.PublicKey, Sequence: 3}},
+		Outputs: []TxOutput{*NewTxOutput(20, string(alice.Address()))},
+	}
+	spend.SetID()
+	if err := spend.Sign(&w.PrivateKey, map[string]*Transaction{fmt.Sprintf("%x", coinbase.ID): coinbase}); err != nil {
+		t.Fatal(err)
+	}
+
+	for tip(t, chain).Height < 2 {
+		if err := chain.CheckLocks(spend); !errors.Is(err, ErrSequenceLocked) {
+			t.Fatalf("CheckLocks at height %d = %v, want %v", tip(t, chain).Height+1, err, ErrSequenceLocked)
+		}
+		early := mineOn(t, chain, tip(t, chain), w, spend)
+		if err := chain.ConnectBlock(early); !errors.Is(err, ErrSequenceLocked) {
+			t.Fatalf("block %d spending early = %v, want %v", early.Height, err, ErrSequenceLocked)
+		}
+		addBlock(t, chain, w)
+	}
+
+	if err := chain.CheckLocks(spend); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, chain, w, spend)
+}
@@ -0,0 +1,332 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+var (
+	ErrTxInvalid   = errors.New("transaction is not valid")
+	ErrTxExists    = errors.New("transaction is already in the memory pool")
+	ErrDoubleSpend = errors.New("transaction spends an output that is not available")
+)
+
+// MempoolOptions bounds the memory pool. Zero values disable the matching limit.
+type MempoolOptions struct {
+	MaxSize int           // maximum number of pooled transactions, the oldest are evicted first
+	MaxAge  time.Duration // transactions older than this are evicted
+}
+
+// DefaultMempoolOptions are the limits used by nodes.
+var DefaultMempoolOptions = MempoolOptions{
+	MaxSize: 5000,
+	MaxAge:  72 * time.Hour,
+}
+
+type mempoolEntry struct {
+	tx    *Transaction
+	added time.Time
+	fee   int // inputs minus outputs
+	size  int // serialized size
+}
+
+// paysMoreThan compares the fee rates of two entries without the rounding of an integer division.
+func (entry *mempoolEntry) paysMoreThan(other *mempoolEntry) bool {
+	return entry.fee*other.size > other.fee*entry.size
+}
+
+// Mempool holds verified transactions waiting to be mined. Every pooled transaction spends outputs of the UTXO set
+// that no other pooled transaction spends.
+type Mempool struct {
+	UTXOSet *UTXOSet
+	Options MempoolOptions
+
+	mu      sync.Mutex
+	entries map[string]*mempoolEntry
+	spent   map[string]string // outpoint to the ID of the pooled transaction spending it
+	now     func() time.Time
+}
+
+// NewMempool creates an empty pool validating transactions against the given UTXO set.
+func NewMempool(UTXOSet *UTXOSet, opts MempoolOptions) *Mempool {
+	return &Mempool{
+		UTXOSet: UTXOSet,
+		Options: opts,
+		entries: make(map[string]*mempoolEntry),
+		spent:   make(map[string]string),
+		now:     time.Now,
+	}
+}
+
+func outpoint(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
+
+// Add verifies a transaction and adds it to the pool. The transaction is rejected when its ID is not its hash or
+// still has unspent outputs on chain, when its signatures do not verify, when its values are out of range or it
+// spends more than its inputs hold, when one of its inputs is already spent, either on chain or by another pooled
+// transaction, or when its locks keep it out of the next block.
+func (mp *Mempool) Add(tx *Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return ErrTxExists
+	}
+
+	if tx.IsCoinbase() {
+		return fmt.Errorf("%w: coinbase %x cannot be pooled", ErrTxInvalid, tx.ID)
+	}
+
+	if !bytes.Equal(tx.ID, tx.UnsignedHash()) {
+		return fmt.Errorf("%w: %x: %v", ErrTxInvalid, tx.ID, ErrBadTxID)
+	}
+
+	if _, err := mp.UTXOSet.Blockchain.Database.Get(utxoKey(tx.ID)); err == nil {
+		return fmt.Errorf("%w: %x: %v", ErrTxInvalid, tx.ID, ErrTxIDInUse)
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+
+	inputs := 0
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		op := outpoint(in.ID, in.Out)
+		if seen[op] {
+			return fmt.Errorf("%w: %s is spent twice by %x", ErrDoubleSpend, op, tx.ID)
+		}
+		seen[op] = true
+
+		if spender, ok := mp.spent[op]; ok {
+			return fmt.Errorf("%w: %s is already spent by pooled transaction %s", ErrDoubleSpend, op, spender)
+		}
+
+		out, ok, err := mp.UTXOSet.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s is not in the UTXO set", ErrDoubleSpend, op)
+		}
+		if inputs, err = addMoney(inputs, out.Value); err != nil {
+			return fmt.Errorf("%w: %x: %v", ErrTxInvalid, tx.ID, err)
+		}
+	}
+
+	outputs := 0
+	for _, out := range tx.Outputs {
+		if out.Value < 0 || (out.Value == 0 && !out.IsUnspendable()) {
+			return fmt.Errorf("%w: %x has an output of %d", ErrTxInvalid, tx.ID, out.Value)
+		}
+		var err error
+		if outputs, err = addMoney(outputs, out.Value); err != nil {
+			return fmt.Errorf("%w: %x: %v", ErrTxInvalid, tx.ID, err)
+		}
+	}
+	if outputs > inputs {
+		return fmt.Errorf("%w: %x spends %d but its inputs hold %d", ErrTxInvalid, tx.ID, outputs, inputs)
+	}
+
+	if err := mp.UTXOSet.Blockchain.CheckLocks(tx); err != nil {
+		return fmt.Errorf("%w: %x: %v", ErrTxInvalid, tx.ID, err)
+	}
+
+	valid, err := mp.UTXOSet.Blockchain.VerifyTx(tx)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return fmt.Errorf("%w: %x has an invalid signature", ErrTxInvalid, tx.ID)
+	}
+
+	mp.entries[txID] = &mempoolEntry{tx, mp.now(), inputs - outputs, tx.Size()}
+	for op := range seen {
+		mp.spent[op] = txID
+	}
+
+	mp.evict()
+
+	return nil
+}
+
+// Has reports whether the transaction is pooled.
+func (mp *Mempool) Has(ID []byte) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	_, ok := mp.entries[hex.EncodeToString(ID)]
+
+	return ok
+}
+
+// Get returns a pooled transaction.
+func (mp *Mempool) Get(ID []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, ok := mp.entries[hex.EncodeToString(ID)]
+	if !ok {
+		return nil, false
+	}
+
+	return entry.tx, true
+}
+
+// Count returns the number of pooled transactions.
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Transactions returns the pooled transactions, oldest first.
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*Transaction
+	for _, entry := range mp.sortedEntries() {
+		txs = append(txs, entry.tx)
+	}
+
+	return txs
+}
+
+// Fee returns the fee of a pooled transaction.
+func (mp *Mempool) Fee(ID []byte) (int, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, ok := mp.entries[hex.EncodeToString(ID)]
+	if !ok {
+		return 0, false
+	}
+
+	return entry.fee, true
+}
+
+// Remove drops a transaction from the pool.
+func (mp *Mempool) Remove(ID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.remove(hex.EncodeToString(ID))
+}
+
+// RemoveBlock drops the transactions mined in the block, together with the pooled transactions that spend the
+// same outputs and can no longer be mined.
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := mp.spent[outpoint(in.ID, in.Out)]; ok {
+				mp.remove(spender)
+			}
+		}
+	}
+}
+
+// Evict drops the transactions that are too old or exceed the size of the pool and returns how many were dropped.
+func (mp *Mempool) Evict() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.evict()
+}
+
+func (mp *Mempool) evict() int {
+	evicted := 0
+	entries := mp.sortedEntries()
+
+	if mp.Options.MaxAge > 0 {
+		oldest := mp.now().Add(-mp.Options.MaxAge)
+		for len(entries) > 0 && entries[0].added.Before(oldest) {
+			mp.remove(hex.EncodeToString(entries[0].tx.ID))
+			entries = entries[1:]
+			evicted++
+		}
+	}
+
+	if mp.Options.MaxSize > 0 {
+		for len(entries) > mp.Options.MaxSize {
+			mp.remove(hex.EncodeToString(entries[0].tx.ID))
+			entries = entries[1:]
+			evicted++
+		}
+	}
+
+	return evicted
+}
+
+// BlockTemplate picks up to max pooled transactions, highest fee rate first and oldest first among equal rates, and
+// puts before them the coinbase paying the subsidy of a block at height and their fees to the miner. The result is
+// ready to be passed to BlockChain.AddBlock. A max of 0 or less takes the whole pool.
+func (mp *Mempool) BlockTemplate(minerAddress string, height, max int) []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entries := mp.sortedEntries()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].paysMoreThan(entries[j]) })
+
+	var txs []*Transaction
+	fees := 0
+
+	for _, entry := range entries {
+		if max > 0 && len(txs) == max {
+			break
+		}
+		txs = append(txs, entry.tx)
+		fees += entry.fee
+	}
+
+	coinbase := CoinBaseTx(minerAddress, "", mp.UTXOSet.Blockchain.params().GetBlockSubsidy(height)+fees)
+
+	return append([]*Transaction{coinbase}, txs...)
+}
+
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+
+	for _, in := range entry.tx.Inputs {
+		op := outpoint(in.ID, in.Out)
+		if mp.spent[op] == txID {
+			delete(mp.spent, op)
+		}
+	}
+	delete(mp.entries, txID)
+}
+
+func (mp *Mempool) sortedEntries() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].added.Equal(entries[j].added) {
+			return hex.EncodeToString(entries[i].tx.ID) < hex.EncodeToString(entries[j].tx.ID)
+		}
+		return entries[i].added.Before(entries[j].added)
+	})
+
+	return entries
+}
@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// mempoolChain creates a chain with blocks coinbases paying w on top of genesis and returns them, genesis first.
+func mempoolChain(t *testing.T, w *wallet.Wallet, blocks int) (*BlockChain, []*Transaction) {
+	t.Helper()
+
+	chain := newTestChain(t, w, Options{})
+	coinbases := []*Transaction{tip(t, chain).Transactions[0]}
+	for i := 0; i < blocks; i++ {
+		coinbases = append(coinbases, addBlock(t, chain, w).Transactions[0])
+	}
+
+	return chain, coinbases
+}
+
+// fakeClock makes the pool see time only move when the test says so.
+func fakeClock(mp *Mempool) *time.Time {
+	now := time.Unix(1700000000, 0)
+	mp.now = func() time.Time { return now }
+
+	return &now
+}
+
+func TestMempoolRejectsDoubleSpend(t *testing.T) {
+	w := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	chain, coinbases := mempoolChain(t, w, 1)
+	mp := NewMempool(&UTXOSet{chain}, MempoolOptions{})
+
+	tx := pay(t, w, coinbases[0], 0, other, 15)
+	if err := mp.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(tx); !errors.Is(err, ErrTxExists) {
+		t.Errorf("adding twice: %v, want %v", err, ErrTxExists)
+	}
+	if err := mp.Add(pay(t, w, coinbases[0], 0, other, 10)); !errors.Is(err, ErrDoubleSpend) {
+		t.Errorf("spending a pooled output: %v, want %v", err, ErrDoubleSpend)
+	}
+
+	// an output the chain has already spent.
+	addBlock(t, chain, w, pay(t, w, coinbases[1], 0, other, 20))
+	if err := mp.Add(pay(t, w, coinbases[1], 0, other, 19)); !errors.Is(err, ErrDoubleSpend) {
+		t.Errorf("spending a mined output: %v, want %v", err, ErrDoubleSpend)
+	}
+
+	if mp.Count() != 1 || !mp.Has(tx.ID) {
+		t.Errorf("pool holds %d transactions, want only %x", mp.Count(), tx.ID)
+	}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	w := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	chain, coinbases := mempoolChain(t, w, 3)
+	mp := NewMempool(&UTXOSet{chain}, MempoolOptions{MaxSize: 2, MaxAge: time.Hour})
+	now := fakeClock(mp)
+
+	var txs []*Transaction
+	for _, coinbase := range coinbases[:3] {
+		tx := pay(t, w, coinbase, 0, other, 19)
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+		*now = now.Add(time.Minute)
+	}
+
+	if mp.Count() != 2 || mp.Has(txs[0].ID) {
+		t.Fatalf("pool holds %d transactions, want the oldest of 3 evicted", mp.Count())
+	}
+	// the output of an evicted transaction can be spent again.
+	if err := mp.Add(pay(t, w, coinbases[0], 0, other, 18)); err != nil {
+		t.Errorf("spending the output of an evicted transaction: %v", err)
+	}
+
+	if evicted := mp.Evict(); evicted != 0 {
+		t.Errorf("Evict dropped %d young transactions", evicted)
+	}
+	*now = now.Add(time.Hour)
+	if evicted := mp.Evict(); evicted != 1 || mp.Count() != 1 {
+		t.Errorf("Evict dropped %d, %d left, want the 1 transaction added an hour ago evicted", evicted, mp.Count())
+	}
+	*now = now.Add(time.Hour)
+	if evicted := mp.Evict(); evicted != 1 || mp.Count() != 0 {
+		t.Errorf("Evict dropped %d, %d left, want the pool emptied", evicted, mp.Count())
+	}
+}
+
+func TestMempoolRemoveBlock(t *testing.T) {
+	w := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	chain, coinbases := mempoolChain(t, w, 2)
+	mp := NewMempool(&UTXOSet{chain}, MempoolOptions{})
+
+	mined := pay(t, w, coinbases[0], 0, other, 19)
+	conflicting := pay(t, w, coinbases[1], 0, other, 19)
+	kept := pay(t, w, coinbases[2], 0, other, 19)
+	for _, tx := range []*Transaction{mined, conflicting, kept} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the block mines one pooled transaction and a different spend of the output of another.
+	block := addBlock(t, chain, w, mined, pay(t, w, coinbases[1], 0, other, 17))
+	mp.RemoveBlock(block)
+
+	if mp.Count() != 1 || !mp.Has(kept.ID) {
+		t.Fatalf("pool holds %d transactions, want only %x", mp.Count(), kept.ID)
+	}
+	// the output the dropped conflict spends is now spent by the chain.
+	if err := mp.Add(conflicting); !errors.Is(err, ErrDoubleSpend) {
+		t.Errorf("re-adding the conflicting transaction: %v, want %v", err, ErrDoubleSpend)
+	}
+}
+
+func TestBlockTemplate(t *testing.T) {
+	w := wallet.MakeWallet()
+	other := wallet.MakeWallet()
+	miner := wallet.MakeWallet()
+	chain, coinbases := mempoolChain(t, w, 2)
+	mp := NewMempool(&UTXOSet{chain}, MempoolOptions{})
+	now := fakeClock(mp)
+
+	// added lowest fee first, so the template has to reorder them.
+	var txs []*Transaction
+	for i, fee := range []int{1, 5, 3} {
+		tx := pay(t, w, coinbases[i], 0, other, 20-fee)
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+		*now = now.Add(time.Second)
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subsidy := chain.params().GetBlockSubsidy(height + 1)
+
+	for _, test := range []struct {
+		max  int
+		want []*Transaction
+		fees int
+	}{
+		{0, []*Transaction{txs[1], txs[2], txs[0]}, 9},
+		{2, []*Transaction{txs[1], txs[2]}, 8},
+		{1, []*Transaction{txs[1]}, 5},
+	} {
+		template := mp.BlockTemplate(string(miner.Address()), height+1, test.max)
+
+		if len(template) != len(test.want)+1 {
+			t.Fatalf("max %d: template has %d transactions, want %d", test.max, len(template), len(test.want)+1)
+		}
+		coinbase := template[0]
+		if !coinbase.IsCoinbase() || coinbase.Outputs[0].Value != subsidy+test.fees {
+			t.Errorf("max %d: first transaction is not a coinbase of %d", test.max, subsidy+test.fees)
+		}
+		for i, tx := range test.want {
+			if !bytes.Equal(template[i+1].ID, tx.ID) {
+				t.Errorf("max %d: transaction %d is %x, want %x", test.max, i+1, template[i+1].ID, tx.ID)
+			}
+		}
+	}
+
+	block, err := chain.AddBlock(mp.BlockTemplate(string(miner.Address()), height+1, 0))
+	if err != nil {
+		t.Fatalf("mining the template: %v", err)
+	}
+	mp.RemoveBlock(block)
+	if mp.Count() != 0 {
+		t.Errorf("pool holds %d transactions after mining the template", mp.Count())
+	}
+}
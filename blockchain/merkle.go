@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// MerkleTree hashes the transactions of a block pairwise up to a single root. Proving that a transaction is part of
+// a block then only takes the sibling hashes along the path from its leaf to the root.
+type MerkleTree struct {
+	RootNode *MerkleNode
+	levels   [][]*MerkleNode // levels[0] holds the leaves, the last level holds the root.
+}
+
+type MerkleNode struct {
+	Left  *MerkleNode
+	Right *MerkleNode
+	Data  []byte
+}
+
+// MerkleProof shows that the transaction TxID sits at position Index of a block. Hashes are the siblings met on the
+// way from the leaf up to the root.
+type MerkleProof struct {
+	TxID   []byte
+	Index  int
+	Hashes [][]byte
+}
+
+// NewMerkleNode hashes the data for a leaf, or the concatenation of both children for a branch.
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
+	node := MerkleNode{}
+
+	if left == nil && right == nil {
+		hash := sha256.Sum256(data)
+		node.Data = hash[:]
+	} else {
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
+		hash := sha256.Sum256(prevHashes)
+		node.Data = hash[:]
+	}
+
+	node.Left = left
+	node.Right = right
+
+	return &node
+}
+
+// NewMerkleTree builds the tree bottom up. A level with an odd number of nodes pairs its last node with itself, so a
+// list ending with a repeated pair has the same root as the list without it: blocks holding a transaction twice are
+// rejected by the validation.
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
+
+	for _, dat := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, dat))
+	}
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, nil))
+	}
+
+	tree := MerkleTree{levels: [][]*MerkleNode{nodes}}
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		var level []*MerkleNode
+		for i := 0; i < len(nodes); i += 2 {
+			level = append(level, NewMerkleNode(nodes[i], nodes[i+1], nil))
+		}
+
+		tree.levels = append(tree.levels, level)
+		nodes = level
+	}
+
+	tree.RootNode = nodes[0]
+
+	return &tree
+}
+
+// Proof collects the sibling hashes of the leaf at index.
+func (t *MerkleTree) Proof(index int) ([][]byte, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf %d is out of range", index)
+	}
+
+	var hashes [][]byte
+
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+
+		hashes = append(hashes, level[sibling].Data)
+		index /= 2
+	}
+
+	return hashes, nil
+}
+
+// MerkleProof builds the inclusion proof of a transaction of the block.
+func (b *Block) MerkleProof(txID []byte) (*MerkleProof, error) {
+	var txHashes [][]byte
+	index := -1
+
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			index = i
+		}
+		txHashes = append(txHashes, tx.ID)
+	}
+
+	if index == -1 {
+		return nil, fmt.Errorf("transaction %x is not in block %x", txID, b.Hash)
+	}
+
+	hashes, err := NewMerkleTree(txHashes).Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleProof{txID, index, hashes}, nil
+}
+
+// Verify hashes the transaction ID up the path and compares the result with the merkle root of a block. The index
+// tells on which side the sibling sits at every level. The ID and the siblings must be hashes, so an inner node,
+// the 64 bytes of its two children, cannot pass for a transaction.
+func (p *MerkleProof) Verify(merkleRoot []byte) bool {
+	if len(p.TxID) != sha256.Size || p.Index < 0 || p.Index>>len(p.Hashes) != 0 {
+		return false
+	}
+	for _, sibling := range p.Hashes {
+		if len(sibling) != sha256.Size {
+			return false
+		}
+	}
+
+	hash := sha256.Sum256(p.TxID)
+	current := hash[:]
+	index := p.Index
+
+	for _, sibling := range p.Hashes {
+		var data []byte
+		if index%2 == 0 {
+			data = append(append(data, current...), sibling...)
+		} else {
+			data = append(append(data, sibling...), current...)
+		}
+
+		hash = sha256.Sum256(data)
+		current = hash[:]
+		index /= 2
+	}
+
+	return bytes.Equal(current, merkleRoot)
+}
@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	var leaves [][]byte
+	for i := 0; i < n; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprint(i)))
+		leaves = append(leaves, hash[:])
+	}
+
+	return leaves
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := testLeaves(n)
+		tree := NewMerkleTree(leaves)
+
+		for i, leaf := range leaves {
+			hashes, err := tree.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proof := MerkleProof{leaf, i, hashes}
+			if !proof.Verify(tree.RootNode.Data) {
+				t.Errorf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+
+			proof.Index = i + 1<<len(hashes)
+			if proof.Verify(tree.RootNode.Data) {
+				t.Errorf("%d leaves: proof of leaf %d verifies at index %d", n, i, proof.Index)
+			}
+		}
+	}
+}
+
+func TestMerkleProofRejectsInnerNode(t *testing.T) {
+	leaves := testLeaves(4)
+	tree := NewMerkleTree(leaves)
+
+	// the concatenation of the two leaves hashes to their parent, one level up.
+	inner := append(append([]byte{}, tree.levels[0][0].Data...), tree.levels[0][1].Data...)
+	proof := MerkleProof{inner, 0, [][]byte{tree.levels[1][1].Data}}
+
+	if proof.Verify(tree.RootNode.Data) {
+		t.Fatal("an inner node passes for a transaction")
+	}
+}
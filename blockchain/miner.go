@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNonceExhausted is returned when no nonce of the nonce space solves the header.
+var ErrNonceExhausted = errors.New("nonce space exhausted")
+
+// hashBatch is how many hashes a worker does between two looks at the cancellation and the hash counter.
+const hashBatch = 1 << 12
+
+// Miner searches proofs of work. The nonce space is split across Workers goroutines, worker i trying the nonces
+// i, i+Workers, i+2*Workers and so on. The zero value is ready to use.
+type Miner struct {
+	Workers        int                           // number of goroutines, runtime.NumCPU() when 0
+	OnHashRate     func(hashesPerSecond float64) // called every ReportInterval while searching
+	ReportInterval time.Duration                 // time.Second when 0
+	MaxNonce       int                           // nonces are searched in [0, MaxNonce), math.MaxInt64 when 0
+}
+
+type solution struct {
+	nonce int
+	hash  []byte
+}
+
+func (m *Miner) workers() int {
+	if m.Workers > 0 {
+		return m.Workers
+	}
+
+	return runtime.NumCPU()
+}
+
+func (m *Miner) maxNonce() int {
+	if m.MaxNonce > 0 {
+		return m.MaxNonce
+	}
+
+	return math.MaxInt64
+}
+
+// Solve finds a nonce for which the block header hashes below the target. It returns ctx.Err() when the context is
+// cancelled first and ErrNonceExhausted when the whole nonce space was searched.
+func (m *Miner) Solve(ctx context.Context, pow *ProofOfWork) (int, []byte, error) {
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := m.workers()
+	results := make(chan solution, workers)
+	var hashes int64
+	var wg sync.WaitGroup
+
+	prefix, suffix := pow.headerParts()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			m.search(searchCtx, pow.Target, prefix, suffix, start, workers, &hashes, results)
+		}(i)
+	}
+
+	if m.OnHashRate != nil {
+		go m.report(searchCtx, &hashes)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// the first solution wins, cancelling the search context stops the other workers.
+	found, ok := <-results
+	cancel()
+
+	if ok {
+		return found.nonce, found.hash, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return 0, nil, ErrNonceExhausted
+}
+
+func (m *Miner) search(ctx context.Context, target *big.Int, prefix, suffix []byte, start, step int, hashes *int64,
+	results chan<- solution) {
+	var intHash big.Int
+
+	data := make([]byte, 0, len(prefix)+8+len(suffix))
+	data = append(data, prefix...)
+	data = append(data, make([]byte, 8)...)
+	data = append(data, suffix...)
+	nonceBytes := data[len(prefix) : len(prefix)+8]
+
+	maxNonce := m.maxNonce()
+	count := 0
+
+	for nonce := start; nonce < maxNonce; nonce += step {
+		binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
+		hash := sha256.Sum256(data)
+		intHash.SetBytes(hash[:])
+
+		if intHash.Cmp(target) == -1 {
+			atomic.AddInt64(hashes, int64(count+1))
+			results <- solution{nonce, hash[:]}
+			return
+		}
+
+		count++
+		if count == hashBatch {
+			atomic.AddInt64(hashes, int64(count))
+			count = 0
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+
+		if nonce > maxNonce-step {
+			break
+		}
+	}
+
+	atomic.AddInt64(hashes, int64(count))
+}
+
+func (m *Miner) report(ctx context.Context, hashes *int64) {
+	interval := m.ReportInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastHashes := int64(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			total := atomic.LoadInt64(hashes)
+			m.OnHashRate(float64(total-lastHashes) / now.Sub(last).Seconds())
+			last, lastHashes = now, total
+		}
+	}
+}
+
+// Mine completes the header with the merkle and witness roots of the transactions and searches its proof of work.
+// When the nonce space is exhausted the extra nonce of the coinbase is increased, which changes both roots and gives
+// a fresh nonce space to search.
+func (m *Miner) Mine(ctx context.Context, header BlockHeader, txs []*Transaction) (*Block, error) {
+	block := &Block{BlockHeader: header, Transactions: txs}
+	block.Version = BlockVersion
+
+	var coinbase *Transaction
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			coinbase = tx
+		}
+	}
+
+	for extraNonce := int64(0); ; extraNonce++ {
+		if extraNonce > 0 {
+			if coinbase == nil {
+				return nil, ErrNonceExhausted
+			}
+			coinbase.SetExtraNonce(extraNonce)
+		}
+
+		block.MerkleRoot = block.HashTransactions()
+		block.WitnessRoot = block.HashWitnesses()
+
+		nonce, hash, err := m.Solve(ctx, NewProof(block))
+		if errors.Is(err, ErrNonceExhausted) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		block.Nonce = nonce
+		block.Hash = hash
+
+		return block, nil
+	}
+}
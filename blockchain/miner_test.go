@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// minerBlock is a block on top of nothing with a coinbase paying w, whose target one hash in 2^hardness meets.
+func minerBlock(w *wallet.Wallet, hardness uint) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:   BlockVersion,
+			Timestamp: time.Now().Unix(),
+			Bits:      BigToCompact(pow2(256 - hardness)),
+			Height:    1,
+		},
+		Transactions: []*Transaction{CoinBaseTx(string(w.Address()), "", 20)},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.WitnessRoot = block.HashWitnesses()
+
+	return block
+}
+
+// solves reports whether nonce solves the header of block.
+func solves(block *Block, nonce int) bool {
+	pow := NewProof(block)
+	hash := sha256.Sum256(pow.InitData(nonce))
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(pow.Target) < 0
+}
+
+func TestSolveSplitsNonceSpace(t *testing.T) {
+	w := wallet.MakeWallet()
+	// a MaxNonce of 0 would not limit the search below the first solution.
+	block := minerBlock(w, 6)
+	for solves(block, 0) {
+		block = minerBlock(w, 6)
+	}
+
+	first := 1
+	for !solves(block, first) {
+		first++
+	}
+
+	for workers := 1; workers <= 4; workers++ {
+		// only the first solution is in the searched range, whichever worker owns it has to find it.
+		miner := Miner{Workers: workers, MaxNonce: first + 1}
+		nonce, hash, err := miner.Solve(context.Background(), NewProof(block))
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if nonce != first {
+			t.Errorf("%d workers: nonce %d, want %d", workers, nonce, first)
+		}
+
+		block.Nonce, block.Hash = nonce, hash
+		if !NewProof(block).Validate() {
+			t.Errorf("%d workers: nonce %d does not validate", workers, nonce)
+		}
+	}
+
+	miner := Miner{Workers: 3, MaxNonce: first}
+	if _, _, err := miner.Solve(context.Background(), NewProof(block)); !errors.Is(err, ErrNonceExhausted) {
+		t.Errorf("Solve below the first solution = %v, want %v", err, ErrNonceExhausted)
+	}
+}
+
+func TestSolveCancelled(t *testing.T) {
+	// no hash meets a target of 1 in practice.
+	block := minerBlock(wallet.MakeWallet(), 256)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rates := make(chan float64, 1)
+	miner := Miner{
+		Workers:        2,
+		ReportInterval: 10 * time.Millisecond,
+		OnHashRate: func(rate float64) {
+			select {
+			case rates <- rate:
+			default:
+			}
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		_, _, err := miner.Solve(ctx, NewProof(block))
+		done <- err
+	}()
+
+	select {
+	case rate := <-rates:
+		if rate <= 0 {
+			t.Errorf("hash rate %f", rate)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("OnHashRate was not called")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Solve = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Solve did not return after the context was cancelled")
+	}
+}
+
+func TestMineRollsExtraNonce(t *testing.T) {
+	w := wallet.MakeWallet()
+
+	// a coinbase whose header nonce 0 does not solve, so a single nonce forces the extra nonce to change.
+	block := minerBlock(w, 4)
+	for solves(block, 0) {
+		block = minerBlock(w, 4)
+	}
+	coinbase := block.Transactions[0]
+	ID := coinbase.ID
+
+	miner := Miner{Workers: 2, MaxNonce: 1}
+	mined, err := miner.Mine(context.Background(), block.BlockHeader, block.Transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(coinbase.ID, ID) || !bytes.Equal(coinbase.ID, coinbase.UnsignedHash()) {
+		t.Fatalf("coinbase ID %x after the extra nonce changed, was %x", coinbase.ID, ID)
+	}
+	if !bytes.Equal(mined.MerkleRoot, mined.HashTransactions()) {
+		t.Error("the merkle root was not recomputed with the extra nonce")
+	}
+	if !bytes.Equal(mined.WitnessRoot, mined.HashWitnesses()) {
+		t.Error("the witness root was not recomputed with the extra nonce")
+	}
+	if mined.Nonce != 0 || !NewProof(mined).Validate() {
+		t.Errorf("nonce %d does not validate", mined.Nonce)
+	}
+}
+
+func TestMineWithoutCoinbaseExhausts(t *testing.T) {
+	block := minerBlock(wallet.MakeWallet(), 256)
+
+	miner := Miner{Workers: 2, MaxNonce: 100}
+	_, err := miner.Mine(context.Background(), block.BlockHeader, nil)
+	if !errors.Is(err, ErrNonceExhausted) {
+		t.Fatalf("Mine = %v, want %v", err, ErrNonceExhausted)
+	}
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+const (
+	// DefaultDataDir is where chains are stored when Options.DataDir is empty.
+	DefaultDataDir = "./tmp"
+	// MainNet is the network used when Options.Network is empty.
+	MainNet = "mainnet"
+)
+
+// Options tell where a chain is stored and which network it belongs to. The zero value opens the mainnet chain in
+// DefaultDataDir.
+type Options struct {
+	DataDir   string          // root of the data directory, DefaultDataDir when empty
+	Network   string          // network name, selects the consensus params, MainNet when empty
+	Badger    *badger.Options // database settings, badger.DefaultOptions when nil, the directories are always set
+	Store     storage.Store   // store of the chain instead of the badger database of the data directory
+	TxIndex   bool            // keep a transaction index, built on opening a chain that has none
+	AddrIndex bool            // keep an address index, built on opening a chain that has none
+}
+
+func (opts Options) network() string {
+	if opts.Network == "" {
+		return MainNet
+	}
+
+	return opts.Network
+}
+
+// NetworkDir is the directory holding the files of the network. Mainnet files live at the root of the data
+// directory, the other networks each get a sub directory so several chains can share a data directory.
+func (opts Options) NetworkDir() string {
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+
+	if opts.network() == MainNet {
+		return dataDir
+	}
+
+	return filepath.Join(dataDir, opts.network())
+}
+
+// ChainDir is the directory of the block database.
+func (opts Options) ChainDir() string {
+	return filepath.Join(opts.NetworkDir(), "blocks")
+}
+
+func (opts Options) badgerOptions() badger.Options {
+	dir := opts.ChainDir()
+
+	if opts.Badger == nil {
+		return badger.DefaultOptions(dir)
+	}
+
+	badgerOpts := *opts.Badger
+	badgerOpts.Dir = dir
+	badgerOpts.ValueDir = dir
+
+	return badgerOpts
+}
+
+// openStore opens the badger database of the data directory, unless opts has a store.
+func (opts Options) openStore() (storage.Store, error) {
+	if opts.Store != nil {
+		return opts.Store, nil
+	}
+
+	return storage.OpenBadger(opts.badgerOptions())
+}
+
+// DBexists reports whether a chain was created with these options.
+func DBexists(opts Options) bool {
+	if opts.Store != nil {
+		_, err := opts.Store.Get([]byte("lh"))
+
+		return err == nil
+	}
+
+	if _, err := os.Stat(filepath.Join(opts.ChainDir(), "MANIFEST")); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,204 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ErrUnknownNetwork is returned for a network name without params.
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// Params are the consensus rules a chain is built and validated with.
+type Params struct {
+	// Name is the network the params belong to.
+	Name string
+	// GenesisBits is the target of the genesis block.
+	GenesisBits uint32
+	// PowLimit is the easiest target a block may have, retargeting never goes above it.
+	PowLimit *big.Int
+	// TargetTimePerBlock is the block interval the retargeting steers towards.
+	TargetTimePerBlock time.Duration
+	// RetargetInterval is the number of blocks between two target adjustments.
+	RetargetInterval int
+	// MaxRetargetFactor bounds a single adjustment, the target changes at most by this factor either way.
+	MaxRetargetFactor int64
+	// NoRetargeting keeps the genesis target for the whole chain.
+	NoRetargeting bool
+	// InitialSubsidy is the value the coinbase of the first blocks may mint.
+	InitialSubsidy int
+	// HalvingInterval is the number of blocks after which the subsidy is halved, 0 never halves it.
+	HalvingInterval int
+	// MaxSupply caps the sum of every subsidy, the subsidy that would exceed it is cut down. 0 disables the cap.
+	MaxSupply int
+}
+
+// DefaultParams are the rules used when a chain does not set its own, the ones of mainnet.
+var DefaultParams = Params{
+	Name:               MainNet,
+	GenesisBits:        InitialBits,
+	PowLimit:           new(big.Int).Lsh(big.NewInt(1), 256-12),
+	TargetTimePerBlock: time.Minute,
+	RetargetInterval:   20,
+	MaxRetargetFactor:  4,
+	InitialSubsidy:     20,
+	HalvingInterval:    1000,
+	MaxSupply:          36000,
+}
+
+// TestNetParams are the mainnet rules starting from the easiest target.
+var TestNetParams = Params{
+	Name:               "testnet",
+	GenesisBits:        BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-12)),
+	PowLimit:           new(big.Int).Lsh(big.NewInt(1), 256-12),
+	TargetTimePerBlock: time.Minute,
+	RetargetInterval:   20,
+	MaxRetargetFactor:  4,
+	InitialSubsidy:     20,
+	HalvingInterval:    1000,
+	MaxSupply:          36000,
+}
+
+// RegTestParams are meant for local testing: every other hash solves a block and the target never changes.
+var RegTestParams = Params{
+	Name:               "regtest",
+	GenesisBits:        BigToCompact(new(big.Int).Lsh(big.NewInt(1), 255)),
+	PowLimit:           new(big.Int).Lsh(big.NewInt(1), 255),
+	TargetTimePerBlock: time.Second,
+	RetargetInterval:   20,
+	MaxRetargetFactor:  4,
+	NoRetargeting:      true,
+	InitialSubsidy:     20,
+	HalvingInterval:    150,
+	MaxSupply:          5000,
+}
+
+// NetworkParams returns the params of a network by name.
+func NetworkParams(name string) (*Params, error) {
+	for _, params := range []*Params{&DefaultParams, &TestNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownNetwork, name)
+}
+
+// GetBlockSubsidy returns the value the coinbase of the block at height may mint on top of the fees. The initial
+// subsidy is halved every HalvingInterval blocks and cut down once the subsidies of the blocks before height reach
+// MaxSupply.
+func (p *Params) GetBlockSubsidy(height int) int {
+	subsidy := p.halvedSubsidy(height)
+
+	if p.MaxSupply > 0 {
+		if left := p.MaxSupply - p.issuedBefore(height); subsidy > left {
+			subsidy = left
+		}
+		if subsidy < 0 {
+			subsidy = 0
+		}
+	}
+
+	return subsidy
+}
+
+func (p *Params) halvings(height int) int {
+	if p.HalvingInterval <= 0 {
+		return 0
+	}
+
+	return height / p.HalvingInterval
+}
+
+func (p *Params) halvedSubsidy(height int) int {
+	halvings := p.halvings(height)
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.InitialSubsidy >> uint(halvings)
+}
+
+// issuedBefore is the sum of the halved subsidies of the blocks below height, ignoring MaxSupply.
+func (p *Params) issuedBefore(height int) int {
+	if p.HalvingInterval <= 0 {
+		return height * p.InitialSubsidy
+	}
+
+	issued := 0
+	for start := 0; start < height; start += p.HalvingInterval {
+		subsidy := p.halvedSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := p.HalvingInterval
+		if start+blocks > height {
+			blocks = height - start
+		}
+		issued += blocks * subsidy
+	}
+
+	return issued
+}
+
+// RetargetTimespan is the expected time between the first and last block of a retarget window. A window of
+// RetargetInterval blocks spans RetargetInterval-1 block intervals.
+func (p *Params) RetargetTimespan() time.Duration {
+	return p.TargetTimePerBlock * time.Duration(p.RetargetInterval-1)
+}
+
+// CalcNextBits scales the target of the last window by how long the window actually took compared to the expected
+// timespan. The result is clamped by MaxRetargetFactor and PowLimit. actualTimespan is in seconds.
+func CalcNextBits(params *Params, lastBits uint32, actualTimespan int64) uint32 {
+	expected := int64(params.RetargetTimespan() / time.Second)
+
+	minTimespan := expected / params.MaxRetargetFactor
+	maxTimespan := expected * params.MaxRetargetFactor
+
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	newTarget := CompactToBig(lastBits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(expected))
+
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+
+	return BigToCompact(newTarget)
+}
+
+func (chain *BlockChain) params() *Params {
+	if chain.Params == nil {
+		return &DefaultParams
+	}
+
+	return chain.Params
+}
+
+// NextBits returns the target bits a block built on top of parent must have. The target only changes on the
+// first block of every retarget window, based on the timestamps of the window that just ended, and never when the
+// params disable retargeting.
+func (chain *BlockChain) NextBits(parent *Block) (uint32, error) {
+	params := chain.params()
+
+	if params.NoRetargeting || (parent.Height+1)%params.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := *parent
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		var err error
+		if first, err = chain.GetBlock(first.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+
+	return CalcNextBits(params, parent.Bits, parent.Timestamp-first.Timestamp), nil
+}
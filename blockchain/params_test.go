@@ -0,0 +1,201 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func pow2(n uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), n)
+}
+
+// retargetParams expect a window of 5 blocks to span 40 seconds.
+var retargetParams = Params{
+	Name:               "retarget",
+	GenesisBits:        BigToCompact(pow2(255)),
+	PowLimit:           pow2(255),
+	TargetTimePerBlock: 10 * time.Second,
+	RetargetInterval:   5,
+	MaxRetargetFactor:  4,
+	InitialSubsidy:     20,
+}
+
+func TestCalcNextBits(t *testing.T) {
+	last := BigToCompact(pow2(240))
+
+	tests := []struct {
+		name     string
+		timespan int64
+		want     *big.Int
+	}{
+		{"on time", 40, pow2(240)},
+		{"too fast", 20, pow2(239)},
+		{"too slow", 80, pow2(241)},
+		{"at the fast clamp", 10, pow2(238)},
+		{"clamped fast", 1, pow2(238)},
+		{"clamped negative", -100, pow2(238)},
+		{"at the slow clamp", 160, pow2(242)},
+		{"clamped slow", 10000, pow2(242)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CompactToBig(CalcNextBits(&retargetParams, last, test.timespan))
+			if got.Cmp(test.want) != 0 {
+				t.Errorf("target %x, want %x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCalcNextBitsPowLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		last     *big.Int
+		timespan int64
+	}{
+		{"at the limit", pow2(255), 160},
+		{"crossing the limit", pow2(254), 160},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CompactToBig(CalcNextBits(&retargetParams, BigToCompact(test.last), test.timespan))
+			if got.Cmp(retargetParams.PowLimit) != 0 {
+				t.Errorf("target %x, want the limit %x", got, retargetParams.PowLimit)
+			}
+		})
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	tests := []struct {
+		name    string
+		spacing int64
+		want    *big.Int
+	}{
+		{"on time", 10, pow2(255)},
+		{"too fast", 5, pow2(254)},
+		{"clamped fast", 1, pow2(253)},
+		{"too slow capped by the limit", 20, pow2(255)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := wallet.MakeWallet()
+			chain := newTestChain(t, w, Options{})
+			params := retargetParams
+			chain.Params = &params
+
+			parent := tip(t, chain)
+			for parent.Height < params.RetargetInterval-1 {
+				if bits, err := chain.NextBits(parent); err != nil || bits != parent.Bits {
+					t.Fatalf("bits %08x, %v within the window at height %d", bits, err, parent.Height+1)
+				}
+
+				block := mineAt(t, chain, parent, parent.Timestamp+test.spacing, w)
+				if err := chain.ConnectBlock(block); err != nil {
+					t.Fatal(err)
+				}
+				parent = block
+			}
+
+			bits, err := chain.NextBits(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := CompactToBig(bits); got.Cmp(test.want) != 0 {
+				t.Errorf("target %x, want %x", got, test.want)
+			}
+
+			params.NoRetargeting = true
+			if bits, err := chain.NextBits(parent); err != nil || bits != parent.Bits {
+				t.Errorf("bits %08x, %v without retargeting", bits, err)
+			}
+		})
+	}
+}
+
+func TestGetBlockSubsidy(t *testing.T) {
+	halving := Params{InitialSubsidy: 50, HalvingInterval: 10}
+	capped := halving
+	capped.MaxSupply = 520
+
+	tests := []struct {
+		name   string
+		params *Params
+		height int
+		want   int
+		issued int
+	}{
+		{"first block", &halving, 0, 50, 0},
+		{"before the first halving", &halving, 9, 50, 450},
+		{"at the first halving", &halving, 10, 25, 500},
+		{"after the first halving", &halving, 11, 25, 525},
+		{"before the second halving", &halving, 19, 25, 725},
+		{"at the second halving", &halving, 20, 12, 750},
+		{"last non-zero subsidy", &halving, 59, 1, 969},
+		{"first zero subsidy", &halving, 60, 0, 970},
+		{"long after the last subsidy", &halving, 1000, 0, 970},
+		{"below the cap", &capped, 9, 50, 450},
+		{"cut down by the cap", &capped, 10, 20, 500},
+		{"past the cap", &capped, 11, 0, 525},
+		{"never halved", &Params{InitialSubsidy: 20}, 1000, 20, 20000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.params.GetBlockSubsidy(test.height); got != test.want {
+				t.Errorf("subsidy %d, want %d", got, test.want)
+			}
+			if got := test.params.issuedBefore(test.height); got != test.issued {
+				t.Errorf("issued before %d, want %d", got, test.issued)
+			}
+		})
+	}
+}
+
+// TestSubsidiesReachMaxSupply mints every block of the networks until the subsidy runs out.
+func TestSubsidiesReachMaxSupply(t *testing.T) {
+	for _, params := range []*Params{&DefaultParams, &TestNetParams, &RegTestParams} {
+		total := 0
+		for height := 0; height < 64*params.HalvingInterval; height++ {
+			total += params.GetBlockSubsidy(height)
+		}
+
+		want := params.issuedBefore(64 * params.HalvingInterval)
+		if params.MaxSupply < want {
+			want = params.MaxSupply
+		}
+		if total != want {
+			t.Errorf("%s: minted %d, want %d", params.Name, total, want)
+		}
+	}
+}
+
+func TestCheckBlockHeaderGenesisBits(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckBlockHeader(&genesis, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	params := RegTestParams
+	params.GenesisBits = BigToCompact(pow2(254))
+	harder, err := Genesis(CoinBaseTx(string(w.Address()), "", 20), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.CheckBlockHeader(harder, time.Now()); !errors.Is(err, ErrBadBits) {
+		t.Fatalf("CheckBlockHeader = %v, want %v", err, ErrBadBits)
+	}
+}
@@ -2,11 +2,10 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
-	"math"
 	"math/big"
 )
 
@@ -23,27 +22,31 @@ import (
 
 const Difficulty = 18
 
+// InitialBits is the compact form of the target matching Difficulty.
+var InitialBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-Difficulty)))
+
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
 }
 
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
+// InitData serializes the block header with the given nonce, this is what gets hashed.
 func (pow *ProofOfWork) InitData(nonce int) []byte {
+	prefix, suffix := pow.headerParts()
+
 	data := bytes.Join(
 		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
+			prefix,
 			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
+			suffix,
 		},
 		[]byte{},
 	)
@@ -51,31 +54,38 @@ func (pow *ProofOfWork) InitData(nonce int) []byte {
 	return data
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
-
-	nonce := 0
+// headerParts returns the serialized header fields found before and after the nonce. Miners only rewrite the nonce
+// between the two for every attempt. The witness root is part of the header from version 2, version 0 keeps the
+// data of the first release.
+func (pow *ProofOfWork) headerParts() ([]byte, []byte) {
+	header := pow.Block.BlockHeader
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+	if header.Version == 0 {
+		return bytes.Join([][]byte{header.PrevHash, pow.Block.legacyHashTransactions()}, []byte{}),
+			ToHex(int64(Difficulty))
+	}
 
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
+	fields := [][]byte{
+		ToHex(int64(header.Version)),
+		header.PrevHash,
+		header.MerkleRoot,
+	}
+	if header.Version >= 2 {
+		fields = append(fields, header.WitnessRoot)
+	}
+	fields = append(fields, ToHex(header.Timestamp), ToHex(int64(header.Bits)))
 
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
-		}
+	return bytes.Join(fields, []byte{}), ToHex(int64(header.Height))
+}
 
-	}
-	fmt.Println()
+// Run searches the nonce with a Miner using its defaults.
+func (pow *ProofOfWork) Run() (int, []byte, error) {
+	var miner Miner
 
-	return nonce, hash[:]
+	return miner.Solve(context.Background(), pow)
 }
 
+// Validate checks that the header hashes below the target and into the hash stored in the block.
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
@@ -84,7 +94,7 @@ func (pow *ProofOfWork) Validate() bool {
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
 
-	return intHash.Cmp(pow.Target) == -1
+	return intHash.Cmp(pow.Target) == -1 && bytes.Equal(hash[:], pow.Block.Hash)
 }
 
 func ToHex(num int64) []byte {
@@ -97,3 +107,43 @@ func ToHex(num int64) []byte {
 
 	return buff.Bytes()
 }
+
+// BigToCompact packs a target into 32 bits: the high byte is the length of the number in bytes and the three low
+// bytes are its most significant bytes. The sign bit of the mantissa is never set, targets are positive.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(n.Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(n, 8*(exponent-3)).Uint64())
+	}
+
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// CompactToBig unpacks a target packed by BigToCompact.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		return big.NewInt(int64(mantissa))
+	}
+
+	target := big.NewInt(int64(mantissa))
+
+	return target.Lsh(target, 8*(exponent-3))
+}
@@ -0,0 +1,554 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// Opcodes of the script language. A push of 1 to 75 bytes uses the number of bytes as opcode.
+const (
+	Op0                   byte = 0x00
+	OpPushData1           byte = 0x4c
+	OpPushData2           byte = 0x4d
+	Op1                   byte = 0x51
+	Op16                  byte = 0x60
+	OpReturn              byte = 0x6a
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpHash160             byte = 0xa9
+	OpCheckSig            byte = 0xac
+	OpCheckMultisig       byte = 0xae
+	OpCheckLockTimeVerify byte = 0xb1
+)
+
+const (
+	maxScriptSize = 10000
+	maxStackSize  = 1000
+	// maxNumSize bounds the numbers scripts compute with, enough for any lock time.
+	maxNumSize = 5
+)
+
+var opNames = map[byte]string{
+	Op0:                   "0",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckMultisig:       "OP_CHECKMULTISIG",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+var (
+	ErrScriptFailed      = errors.New("script failed")
+	ErrMalformedScript   = errors.New("malformed script")
+	ErrNonStandardScript = errors.New("script is not a standard one")
+)
+
+// instruction is an opcode and the data it pushes.
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+func (in instruction) isPush() bool {
+	return in.op <= OpPushData2 || (in.op >= Op1 && in.op <= Op16)
+}
+
+// parseScript splits a script in instructions.
+func parseScript(script []byte) ([]instruction, error) {
+	var instructions []instruction
+
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformedScript, len(script))
+	}
+
+	for pc := 0; pc < len(script); {
+		op := script[pc]
+		pc++
+
+		size := 0
+		switch {
+		case op > Op0 && op < OpPushData1:
+			size = int(op)
+		case op == OpPushData1:
+			if pc+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrMalformedScript)
+			}
+			size = int(script[pc])
+			pc++
+		case op == OpPushData2:
+			if pc+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrMalformedScript)
+			}
+			size = int(binary.LittleEndian.Uint16(script[pc:]))
+			pc += 2
+		}
+
+		if pc+size > len(script) {
+			return nil, fmt.Errorf("%w: truncated push", ErrMalformedScript)
+		}
+		instructions = append(instructions, instruction{op, script[pc : pc+size]})
+		pc += size
+	}
+
+	return instructions, nil
+}
+
+// PushData appends to script the shortest push of data.
+func PushData(script, data []byte) []byte {
+	switch {
+	case len(data) == 0:
+		return append(script, Op0)
+	case len(data) < int(OpPushData1):
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, OpPushData1, byte(len(data)))
+	default:
+		script = append(script, OpPushData2, byte(len(data)), byte(len(data)>>8))
+	}
+
+	return append(script, data...)
+}
+
+// PushNum appends to script the push of n, OP_1 to OP_16 for the small numbers.
+func PushNum(script []byte, n int64) []byte {
+	if n >= 1 && n <= 16 {
+		return append(script, Op1+byte(n-1))
+	}
+
+	return PushData(script, encodeNum(n))
+}
+
+// PayToPubKeyHashScript locks outputs to the key hashing to pubKeyHash:
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG.
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	script := []byte{OpDup, OpHash160}
+	script = PushData(script, pubKeyHash)
+
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// PayToMultisigHashScript locks outputs to the multisig hashing to multisigHash: OP_HASH160 <multisigHash> OP_EQUAL.
+// The spending input pushes the serialized multisig last, its MultisigScript is run on the rest of the stack once
+// its hash matched.
+func PayToMultisigHashScript(multisigHash []byte) []byte {
+	script := []byte{OpHash160}
+	script = PushData(script, multisigHash)
+
+	return append(script, OpEqual)
+}
+
+// MultisigScript is the script of a multisig: M <keys> N OP_CHECKMULTISIG.
+func MultisigScript(ms *wallet.Multisig) []byte {
+	script := PushNum(nil, int64(ms.M))
+	for _, key := range ms.PubKeys {
+		script = PushData(script, key)
+	}
+	script = PushNum(script, int64(len(ms.PubKeys)))
+
+	return append(script, OpCheckMultisig)
+}
+
+// LockTimeScript locks outputs to the key hashing to pubKeyHash until lockTime, a height or a unix time from
+// LockTimeThreshold on like the LockTime of a transaction: <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP followed by the
+// PayToPubKeyHashScript. The spending transaction needs a LockTime of the same kind at least lockTime, so it is
+// mined after it.
+func LockTimeScript(lockTime int64, pubKeyHash []byte) []byte {
+	script := PushNum(nil, lockTime)
+	script = append(script, OpCheckLockTimeVerify, OpDrop)
+
+	return append(script, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// DataScript makes an output unspendable and carries data: OP_RETURN <data>.
+func DataScript(data []byte) []byte {
+	return PushData([]byte{OpReturn}, data)
+}
+
+// payToPubKeyHash returns the public key hash of a PayToPubKeyHashScript.
+func payToPubKeyHash(script []byte) ([]byte, bool) {
+	instructions, err := parseScript(script)
+	if err != nil || len(instructions) != 5 {
+		return nil, false
+	}
+
+	pubKeyHash := instructions[2].data
+	if !bytes.Equal(PayToPubKeyHashScript(pubKeyHash), script) {
+		return nil, false
+	}
+
+	return pubKeyHash, true
+}
+
+// lockTimeScript returns the lock time and the public key hash of a LockTimeScript.
+func lockTimeScript(script []byte) (int64, []byte, bool) {
+	instructions, err := parseScript(script)
+	if err != nil || len(instructions) != 8 || !instructions[0].isPush() {
+		return 0, nil, false
+	}
+
+	lockTime, err := decodeNum(instructions[0].data)
+	if instructions[0].op >= Op1 && instructions[0].op <= Op16 {
+		lockTime, err = int64(instructions[0].op-Op1+1), nil
+	}
+	pubKeyHash := instructions[5].data
+	if err != nil || !bytes.Equal(LockTimeScript(lockTime, pubKeyHash), script) {
+		return 0, nil, false
+	}
+
+	return lockTime, pubKeyHash, true
+}
+
+// DisassembleScript renders a script as its opcode names and the hex of the data it pushes.
+func DisassembleScript(script []byte) string {
+	var words []string
+
+	instructions, err := parseScript(script)
+	for _, in := range instructions {
+		switch {
+		case in.op >= Op1 && in.op <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", in.op-Op1+1))
+		case in.op > Op0 && in.op <= OpPushData2:
+			words = append(words, hex.EncodeToString(in.data))
+		case opNames[in.op] != "":
+			words = append(words, opNames[in.op])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%#x", in.op))
+		}
+	}
+	if err != nil {
+		words = append(words, "[error]")
+	}
+
+	return strings.Join(words, " ")
+}
+
+// scriptEngine runs scripts on a stack. checkSig tells whether a signature of the spending transaction is valid
+// for a public key, tx is the spending transaction and input the index of the input being verified.
+type scriptEngine struct {
+	stack    [][]byte
+	checkSig func(signature, pubKey []byte) bool
+	tx       *Transaction
+	input    int
+}
+
+func (e *scriptEngine) push(data []byte) error {
+	if len(e.stack) >= maxStackSize {
+		return fmt.Errorf("%w: stack overflow", ErrScriptFailed)
+	}
+	e.stack = append(e.stack, data)
+
+	return nil
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+
+	return top, nil
+}
+
+func (e *scriptEngine) popNum() (int64, error) {
+	data, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeNum(data)
+}
+
+func (e *scriptEngine) pushBool(b bool) error {
+	if b {
+		return e.push([]byte{1})
+	}
+
+	return e.push(nil)
+}
+
+// execute runs script on the stack.
+func (e *scriptEngine) execute(script []byte) error {
+	instructions, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, in := range instructions {
+		if err := e.step(in); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *scriptEngine) step(in instruction) error {
+	switch {
+	case in.op <= OpPushData2:
+		return e.push(in.data)
+	case in.op >= Op1 && in.op <= Op16:
+		return e.push(encodeNum(int64(in.op - Op1 + 1)))
+	}
+
+	switch in.op {
+	case OpReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+
+	case OpDup:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+		}
+		return e.push(e.stack[len(e.stack)-1])
+
+	case OpHash160:
+		data, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.push(wallet.PublicKeyHash(data))
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if in.op == OpEqualVerify {
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return e.pushBool(bytes.Equal(a, b))
+
+	case OpCheckSig:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		return e.pushBool(len(signature) != 0 && e.checkSig(signature, pubKey))
+
+	case OpCheckMultisig:
+		return e.checkMultisig()
+
+	// the lock time is left on the stack, scripts follow it with OP_DROP. Comparing it with the LockTime of the
+	// transaction, which checkLocks holds to the block, keeps the result of the script the same in every block.
+	case OpCheckLockTimeVerify:
+		if len(e.stack) == 0 {
+			return fmt.Errorf("%w: stack underflow", ErrScriptFailed)
+		}
+		lockTime, err := decodeNum(e.stack[len(e.stack)-1])
+		if err != nil {
+			return err
+		}
+		switch {
+		case lockTime < 0:
+			return fmt.Errorf("%w: negative lock time %d", ErrScriptFailed, lockTime)
+		case (lockTime < LockTimeThreshold) != (e.tx.LockTime < LockTimeThreshold):
+			return fmt.Errorf("%w: lock time %d and transaction lock time %d are not of the same kind",
+				ErrScriptFailed, lockTime, e.tx.LockTime)
+		case lockTime > e.tx.LockTime:
+			return fmt.Errorf("%w: locked until %d, transaction lock time %d", ErrScriptFailed, lockTime,
+				e.tx.LockTime)
+		case e.tx.Inputs[e.input].Sequence == SequenceFinal:
+			return fmt.Errorf("%w: input %d is final, its transaction lock time is not enforced", ErrScriptFailed,
+				e.input)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown opcode %#x", ErrScriptFailed, in.op)
+}
+
+// checkMultisig pops N, the N keys, M and one signature slot per key, empty for the keys that did not sign, and
+// pushes whether at least M slots hold a valid signature. A slot holding an invalid signature fails the script.
+func (e *scriptEngine) checkMultisig() error {
+	n, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > wallet.MaxMultisigKeys {
+		return fmt.Errorf("%w: %d keys", ErrScriptFailed, n)
+	}
+
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return err
+		}
+	}
+
+	m, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if m < 1 || m > n {
+		return fmt.Errorf("%w: %d of %d signatures", ErrScriptFailed, m, n)
+	}
+
+	signed := int64(0)
+	for i := n - 1; i >= 0; i-- {
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if len(signature) == 0 {
+			continue
+		}
+		if !e.checkSig(signature, pubKeys[i]) {
+			return fmt.Errorf("%w: invalid signature for key %d", ErrScriptFailed, i)
+		}
+		signed++
+	}
+
+	return e.pushBool(signed >= m)
+}
+
+// verifyScripts runs the unlocking script, then the locking script on the stack it leaves. A locking script paying
+// to a multisig hash then runs the script of the multisig the unlocking script pushed last. Unlocking scripts may
+// only push data and the scripts must leave a single true value.
+func (e *scriptEngine) verifyScripts(unlocking, locking []byte) error {
+	instructions, err := parseScript(unlocking)
+	if err != nil {
+		return err
+	}
+	for _, in := range instructions {
+		if !in.isPush() {
+			return fmt.Errorf("%w: unlocking script does not only push data", ErrScriptFailed)
+		}
+	}
+
+	if err := e.execute(unlocking); err != nil {
+		return err
+	}
+	unlocked := append([][]byte(nil), e.stack...)
+
+	if err := e.execute(locking); err != nil {
+		return err
+	}
+
+	if _, ok := payToMultisigHash(locking); ok {
+		if top, err := e.pop(); err != nil || !castToBool(top) {
+			return fmt.Errorf("%w: multisig does not match the hash", ErrScriptFailed)
+		}
+		e.stack = unlocked
+		redeem, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ms, err := wallet.DeserializeMultisig(redeem)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrScriptFailed, err)
+		}
+		if err := e.execute(MultisigScript(ms)); err != nil {
+			return err
+		}
+	}
+
+	return e.verifyResult()
+}
+
+func (e *scriptEngine) verifyResult() error {
+	if len(e.stack) != 1 || !castToBool(e.stack[0]) {
+		return fmt.Errorf("%w: script did not leave a single true value", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+// payToMultisigHash returns the multisig hash of a PayToMultisigHashScript.
+func payToMultisigHash(script []byte) ([]byte, bool) {
+	instructions, err := parseScript(script)
+	if err != nil || len(instructions) != 3 {
+		return nil, false
+	}
+
+	multisigHash := instructions[1].data
+	if !bytes.Equal(PayToMultisigHashScript(multisigHash), script) {
+		return nil, false
+	}
+
+	return multisigHash, true
+}
+
+// castToBool is false for the empty value and the encodings of zero, negative zero included.
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// encodeNum encodes n little endian, the top bit of the last byte being the sign.
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var data []byte
+	for ; n > 0; n >>= 8 {
+		data = append(data, byte(n))
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		data = append(data, 0)
+	}
+	if negative {
+		data[len(data)-1] |= 0x80
+	}
+
+	return data
+}
+
+func decodeNum(data []byte) (int64, error) {
+	if len(data) > maxNumSize {
+		return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(data))
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * i)
+	}
+
+	last := data[len(data)-1]
+	if last&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(data) - 1))
+		return -n, nil
+	}
+
+	return n, nil
+}
@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func TestCheckLockTimeVerify(t *testing.T) {
+	w := wallet.MakeWallet()
+	unlocking := PushData(PushData(nil, []byte("signature")), w.PublicKey)
+
+	tests := []struct {
+		name     string
+		lockTime int64
+		txLock   int64
+		sequence uint32
+		ok       bool
+	}{
+		{"at the lock", 10, 10, 0, true},
+		{"after the lock", 10, 20, 0, true},
+		{"before the lock", 10, 9, 0, false},
+		{"small lock", 3, 3, 0, true},
+		{"time lock", LockTimeThreshold + 100, LockTimeThreshold + 100, 0, true},
+		{"time before the lock", LockTimeThreshold + 100, LockTimeThreshold + 99, 0, false},
+		{"height against a time", 10, LockTimeThreshold, 0, false},
+		{"time against a height", LockTimeThreshold, LockTimeThreshold - 1, 0, false},
+		{"final input", 10, 10, SequenceFinal, false},
+		{"relative lock disabled", 10, 10, SequenceLockTimeDisabled, true},
+		{"negative lock", -1, 10, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := &Transaction{Inputs: []TxInput{{Sequence: test.sequence}}, LockTime: test.txLock}
+			engine := scriptEngine{
+				checkSig: func(signature, pubKey []byte) bool { return true },
+				tx:       tx,
+			}
+
+			err := engine.verifyScripts(unlocking, LockTimeScript(test.lockTime, wallet.PublicKeyHash(w.PublicKey)))
+			if test.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !test.ok && !errors.Is(err, ErrScriptFailed) {
+				t.Fatalf("verifyScripts = %v, want %v", err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestLockTimeScriptParses(t *testing.T) {
+	pubKeyHash := wallet.PublicKeyHash(wallet.MakeWallet().PublicKey)
+
+	for _, lockTime := range []int64{0, 1, 16, 17, 1000, LockTimeThreshold + 1} {
+		got, hash, ok := lockTimeScript(LockTimeScript(lockTime, pubKeyHash))
+		if !ok || got != lockTime || string(hash) != string(pubKeyHash) {
+			t.Errorf("lock time %d parsed as %d, %x, %v", lockTime, got, hash, ok)
+		}
+	}
+
+	if _, _, ok := lockTimeScript(PayToPubKeyHashScript(pubKeyHash)); ok {
+		t.Error("a pay to public key hash script parsed as a lock time script")
+	}
+}
+
+func TestIsFinalWithFinalSequences(t *testing.T) {
+	tx := &Transaction{Inputs: []TxInput{{Sequence: SequenceFinal}, {Sequence: SequenceFinal}}, LockTime: 100}
+	if !tx.IsFinal(5, 0) {
+		t.Error("transaction with final inputs is not final")
+	}
+
+	tx.Inputs[1].Sequence = 0
+	if tx.IsFinal(5, 0) {
+		t.Error("lock time of a transaction with an input that is not final ignored")
+	}
+	if !tx.IsFinal(101, 0) {
+		t.Error("transaction not final past its lock time")
+	}
+}
+
+func TestSpendLockTimeOutput(t *testing.T) {
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	utxo := &UTXOSet{chain}
+
+	tx, err := NewTransaction(w, string(alice.Address()), 15, utxo, TxOptions{LockOutput: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, chain, w, tx)
+
+	for tip(t, chain).Height < 3 {
+		if _, err := NewTransaction(alice, string(w.Address()), 10, utxo, TxOptions{}); !errors.Is(err,
+			ErrInsufficientFunds) {
+			t.Fatalf("spending at height %d = %v, want %v", tip(t, chain).Height+1, err, ErrInsufficientFunds)
+		}
+		addBlock(t, chain, w)
+	}
+
+	spend, err := NewTransaction(alice, string(w.Address()), 10, utxo, TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spend.LockTime != 3 {
+		t.Errorf("lock time %d, want 3", spend.LockTime)
+	}
+	if err := chain.CheckLocks(spend); err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, chain, w, spend)
+
+	// a lower lock time, or a final input, fails the script.
+	for _, forge := range []func(*Transaction){
+		func(tx *Transaction) { tx.LockTime = 1 },
+		func(tx *Transaction) { tx.Inputs[0].Sequence = SequenceFinal },
+	} {
+		chain := newTestChain(t, w, Options{})
+		utxo := &UTXOSet{chain}
+		tx, err := NewTransaction(w, string(alice.Address()), 15, utxo, TxOptions{LockOutput: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addBlock(t, chain, w, tx)
+		addBlock(t, chain, w)
+
+		spend := &Transaction{
+			Inputs:   []TxInput{{ID: tx.ID, Out: 0, PubKey: alice.PublicKey}},
+			Outputs:  []TxOutput{*NewTxOutput(15, string(w.Address()))},
+			LockTime: 2,
+		}
+		forge(spend)
+		spend.SetID()
+		if err := spend.Sign(&alice.PrivateKey, map[string]*Transaction{fmt.Sprintf("%x", tx.ID): tx}); err != nil {
+			t.Fatal(err)
+		}
+
+		block := mineOn(t, chain, tip(t, chain), w, spend)
+		if err := chain.ConnectBlock(block); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("ConnectBlock = %v, want %v", err, ErrBadSignature)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func TestSignShortCoordinates(t *testing.T) {
+	w := wallet.MakeWallet()
+	prev := &Transaction{Outputs: []TxOutput{*NewTxOutput(20, string(w.Address()))}}
+	prev.SetID()
+	prevTXs := map[string]*Transaction{hex.EncodeToString(prev.ID): prev}
+
+	// about one signature in 64 has an r or s with a leading zero byte.
+	for amount := 1; amount <= 500; amount++ {
+		tx := &Transaction{
+			Inputs:  []TxInput{{ID: prev.ID, Out: 0, PubKey: w.PublicKey}},
+			Outputs: []TxOutput{*NewTxOutput(amount, string(w.Address()))},
+		}
+		tx.SetID()
+		if err := tx.Sign(&w.PrivateKey, prevTXs); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tx.Inputs[0].Signature) != 2*coordinateSize {
+			t.Fatalf("signature of %d bytes", len(tx.Inputs[0].Signature))
+		}
+		if !tx.Verify(prevTXs) {
+			t.Fatalf("signature %x does not verify", tx.Inputs[0].Signature)
+		}
+	}
+}
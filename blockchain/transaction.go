@@ -6,192 +6,663 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/tensor-programming/golang-blockchain/wallet"
-	"log"
 	"math/big"
 	"strings"
 )
 
+// coordinateSize is the size of the r and s halves of a signature.
+const coordinateSize = 32
+
+var (
+	// ErrInsufficientFunds is returned when a wallet cannot cover the amount of a transaction.
+	ErrInsufficientFunds = errors.New("not enough funds")
+	// ErrNotCosigner is returned when a key signs a multisig input it is not part of.
+	ErrNotCosigner = errors.New("key is not part of the multisig")
+)
+
+// TxOptions set the fee of a new transaction and how its inputs are picked. Fee takes precedence, FeeRate is only
+// used when Fee is 0. The zero value creates a transaction without fee spending the largest outputs first.
+type TxOptions struct {
+	Fee          int          // absolute fee
+	FeeRate      int          // fee per 1000 bytes of the serialized transaction, rounded up
+	CoinSelector CoinSelector // LargestFirst when nil
+	Data         []byte       // data carried by an unspendable output when set
+	LockTime     int64        // lock time of the transaction
+	Sequence     uint32       // sequence of every input, holding their relative lock
+	LockOutput   int64        // locks the output paying the to address with a LockTimeScript when set
+}
+
+// FeeForSize returns the fee a transaction of size bytes pays at feeRate per 1000 bytes.
+func FeeForSize(size, feeRate int) int {
+	return (size*feeRate + 999) / 1000
+}
+
 // Transaction store information as i/p and output struct as we don't want to store any relative
 // information for amount, sender, receiver. It will be stored in public databases.
 type Transaction struct {
 	ID      []byte
 	Inputs  []TxInput
 	Outputs []TxOutput
+	// LockTime is the height, or the time from LockTimeThreshold on, the transaction cannot be mined before.
+	LockTime int64
 }
 
+// Hash is the hash of the hashEncoding of the transaction without its ID, which the signatures are based on.
 func (tx *Transaction) Hash() []byte {
 	txCopy := *tx
 	txCopy.ID = []byte{}
-	hash := sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(txCopy.hashEncoding())
 
 	return hash[:]
 }
 
+// UnsignedHash is the Hash of the transaction without the signatures of its inputs, which SetID makes the ID so
+// signing leaves the ID unchanged. A coinbase input has no signature but the extra nonce, which is kept.
+func (tx *Transaction) UnsignedHash() []byte {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+
+	txCopy := *tx
+	txCopy.Inputs = make([]TxInput, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		in.Signature = nil
+		in.ScriptSig = nil
+		if in.Signatures != nil {
+			in.Signatures = make([][]byte, len(in.Signatures))
+		}
+		txCopy.Inputs[i] = in
+	}
+
+	return txCopy.Hash()
+}
+
+// Tags of the fields of hashEncoding added after the fields of the first transactions.
+const (
+	tagEnd byte = iota
+	tagVersion
+	tagRedeem
+	tagSignatures
+	tagScript
+	tagScriptSig
+	tagSequence
+	tagLockTime
+)
+
+// hashEncoding is the encoding Hash, and so the IDs and the signatures, are computed over.
+//
+// Gob encodes the fields of the types along with their values, so it would change the hashes of all the
+// transactions whenever a field is added. Transactions using only the fields of the first release keep the gob
+// encoding they were hashed with then, legacyEncoding, which leaves the IDs and signatures of the blocks of that
+// release valid. Every other transaction gets the encoding below, where each later field is tagged and only written
+// when set, so adding a field does not change the hashes of the transactions leaving it unset.
+func (tx *Transaction) hashEncoding() []byte {
+	if !tx.extended() {
+		return tx.legacyEncoding()
+	}
+
+	var buf bytes.Buffer
+	writeBytes := func(data []byte) {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+		buf.Write(data)
+	}
+	writeInt := func(n int) {
+		buf.Write(binary.AppendVarint(nil, int64(n)))
+	}
+
+	writeBytes(tx.ID)
+	writeInt(len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		writeBytes(in.ID)
+		writeInt(in.Out)
+		writeBytes(in.Signature)
+		writeBytes(in.PubKey)
+		if in.Redeem != nil {
+			buf.WriteByte(tagRedeem)
+			writeBytes(in.Redeem)
+		}
+		if in.Signatures != nil {
+			buf.WriteByte(tagSignatures)
+			writeInt(len(in.Signatures))
+			for _, signature := range in.Signatures {
+				writeBytes(signature)
+			}
+		}
+		if in.ScriptSig != nil {
+			buf.WriteByte(tagScriptSig)
+			writeBytes(in.ScriptSig)
+		}
+		if in.Sequence != 0 {
+			buf.WriteByte(tagSequence)
+			buf.Write(binary.AppendUvarint(nil, uint64(in.Sequence)))
+		}
+		buf.WriteByte(tagEnd)
+	}
+
+	writeInt(len(tx.Outputs))
+	for _, out := range tx.Outputs {
+		writeInt(out.Value)
+		writeBytes(out.PubKeyHash)
+		if out.Version != 0 {
+			buf.WriteByte(tagVersion)
+			buf.WriteByte(out.Version)
+		}
+		if out.Script != nil {
+			buf.WriteByte(tagScript)
+			writeBytes(out.Script)
+		}
+		buf.WriteByte(tagEnd)
+	}
+
+	if tx.LockTime != 0 {
+		buf.WriteByte(tagLockTime)
+		buf.Write(binary.AppendVarint(nil, tx.LockTime))
+	}
+
+	return buf.Bytes()
+}
+
+// extended reports whether the transaction sets fields added after the first release.
+func (tx *Transaction) extended() bool {
+	if tx.LockTime != 0 {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Redeem != nil || in.Signatures != nil || in.ScriptSig != nil || in.Sequence != 0 {
+			return true
+		}
+	}
+	for _, out := range tx.Outputs {
+		if out.Version != 0 || out.Script != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// legacyTypes starts the gob stream of a Transaction of the first release in a process that had encoded nothing
+// before, as when the first release created a chain: the definitions of its types, numbered from 64.
+const legacyTypes = "" +
+	"387f0301010b5472616e73616374696f6e01ff8000010301024944010a000106496e7075747301ff840001074f757470" +
+	"75747301ff8800000023ff83020101145b5d626c6f636b636861696e2e5478496e70757401ff840001ff8200003dff81" +
+	"030101075478496e70757401ff8200010401024944010a0001034f757401040001095369676e6174757265010a000106" +
+	"5075624b6579010a00000024ff87020101155b5d626c6f636b636861696e2e54784f757470757401ff880001ff860000" +
+	"2fff850301010854784f757470757401ff86000102010556616c7565010400010a5075624b657948617368010a000000"
+
+// legacyEncoding is the gob encoding of the transaction with the types of the first release, gob naming the types
+// by their bare names. See hashEncoding. Gob numbers the types in the order a process first encodes them, which
+// would make the encoding depend on what the process encoded before, so the value is sent after legacyTypes with
+// their numbering whatever the numbering of the process.
+func (tx *Transaction) legacyEncoding() []byte {
+	type TxInput struct {
+		ID        []byte
+		Out       int
+		Signature []byte
+		PubKey    []byte
+	}
+	type TxOutput struct {
+		Value      int
+		PubKeyHash []byte
+	}
+	type Transaction struct {
+		ID      []byte
+		Inputs  []TxInput
+		Outputs []TxOutput
+	}
+
+	legacy := Transaction{ID: tx.ID}
+	for _, in := range tx.Inputs {
+		legacy.Inputs = append(legacy.Inputs, TxInput{in.ID, in.Out, in.Signature, in.PubKey})
+	}
+	for _, out := range tx.Outputs {
+		legacy.Outputs = append(legacy.Outputs, TxOutput{out.Value, out.PubKeyHash})
+	}
+
+	// the first Encode sends the type definitions and the value, the second one the value alone: its length, the
+	// number of its type and its fields.
+	var encoded bytes.Buffer
+	encoder := gob.NewEncoder(&encoded)
+	mustEncode(encoder.Encode(legacy))
+	encoded.Reset()
+	mustEncode(encoder.Encode(legacy))
+
+	value := encoded.Bytes()
+	_, n := gobUint(value)
+	_, m := gobUint(value[n:])
+	message := append([]byte{0xff, 0x80}, value[n+m:]...) // type 64
+
+	stream, err := hex.DecodeString(legacyTypes)
+	mustEncode(err)
+	stream = appendGobUint(stream, uint64(len(message)))
+
+	return append(stream, message...)
+}
+
+// gobUint decodes an unsigned integer the way gob encodes it and returns it with the number of bytes it took:
+// values below 128 take one byte, larger ones a byte holding the negated length of their big endian bytes first.
+func gobUint(data []byte) (uint64, int) {
+	if data[0] < 0x80 {
+		return uint64(data[0]), 1
+	}
+
+	n := int(-int8(data[0]))
+	var x uint64
+	for _, b := range data[1 : 1+n] {
+		x = x<<8 | uint64(b)
+	}
+
+	return x, 1 + n
+}
+
+// appendGobUint appends x encoded the way gobUint decodes it.
+func appendGobUint(data []byte, x uint64) []byte {
+	if x < 0x80 {
+		return append(data, byte(x))
+	}
+
+	var bigEndian [8]byte
+	binary.BigEndian.PutUint64(bigEndian[:], x)
+	n := 8
+	for bigEndian[8-n] == 0 {
+		n--
+	}
+
+	return append(append(data, byte(-int8(n))), bigEndian[8-n:]...)
+}
+
+// Size is the length of the serialized transaction, which fee rates are based on.
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
 func (tx *Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
 
 	encode := gob.NewEncoder(&encoded)
 	err := encode.Encode(tx)
-	Handle(err)
+	mustEncode(err)
 
 	return encoded.Bytes()
 }
 
-// SetID is setting ID of encoded tx and then hashed.
+// DeserializeTransaction decodes a transaction produced by Serialize.
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&transaction); err != nil {
+		return Transaction{}, fmt.Errorf("decoding transaction: %w", err)
+	}
+
+	return transaction, nil
+}
+
+// SetID sets the ID of the transaction to its UnsignedHash, the only ID blocks and the memory pool accept.
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
+	tx.ID = tx.UnsignedHash()
+}
 
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	Handle(err)
+// NewTransaction create a new transaction paying amount to the to address from the outputs of the wallet w, the
+// change going back to w. The fee is what the inputs hold on top of the outputs. With a fee rate the fee is estimated
+// from the size of the signed transaction, picking more outputs until they cover amount and the fee. It returns
+// ErrInsufficientFunds when the outputs of w are worth less than amount plus the fee, and wallet.ErrWalletLocked
+// when w has no private key.
+func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet, opts TxOptions) (*Transaction, error) {
+	if w.Locked() {
+		return nil, wallet.ErrWalletLocked
+	}
+
+	build := func(fee int) (*Transaction, error) {
+		tx, err := fundTransaction(wallet.PublicKeyHash(w.PublicKey), string(w.Address()), to, amount, fee,
+			opts, UTXO, func(txID []byte, out int) TxInput {
+				return TxInput{ID: txID, Out: out, PubKey: w.PublicKey}
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := UTXO.Blockchain.SignTx(tx, &w.PrivateKey); err != nil {
+			return nil, err
+		}
+
+		return tx, nil
+	}
+
+	return withFee(opts, build, (*Transaction).Size)
+}
+
+// NewMultisigTransaction creates an unsigned transaction paying amount to the to address from the outputs of the
+// multisig, the change going back to the multisig. Every input gets an empty signature slot per key, for the
+// participants to fill with SignTx until M of them signed. With a fee rate the size of the M signatures is
+// estimated.
+func NewMultisigTransaction(ms *wallet.Multisig, to string, amount int, UTXO *UTXOSet, opts TxOptions) (*Transaction, error) {
+	redeem := ms.Serialize()
+
+	build := func(fee int) (*Transaction, error) {
+		tx, err := fundTransaction(ms.Hash(), string(ms.Address()), to, amount, fee, opts, UTXO,
+			func(txID []byte, out int) TxInput {
+				return TxInput{ID: txID, Out: out, Redeem: redeem, Signatures: make([][]byte, len(ms.PubKeys))}
+			})
+		if err != nil {
+			return nil, err
+		}
 
-	hash := sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+		return tx, nil
+	}
+
+	signedSize := func(tx *Transaction) int {
+		signed := *tx
+		signed.Inputs = make([]TxInput, len(tx.Inputs))
+		for i, in := range tx.Inputs {
+			in.Signatures = make([][]byte, len(ms.PubKeys))
+			for slot := 0; slot < ms.M; slot++ {
+				in.Signatures[slot] = make([]byte, 2*coordinateSize)
+			}
+			signed.Inputs[i] = in
+		}
+
+		return signed.Size()
+	}
+
+	return withFee(opts, build, signedSize)
+}
+
+// withFee builds a transaction paying the fee of opts. With a fee rate the transaction is built again with the fee
+// its size calls for, until the fee covers it.
+func withFee(opts TxOptions, build func(fee int) (*Transaction, error), size func(*Transaction) int) (*Transaction, error) {
+	fee := opts.Fee
+
+	for {
+		tx, err := build(fee)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Fee > 0 || opts.FeeRate <= 0 {
+			return tx, nil
+		}
+
+		needed := FeeForSize(size(tx), opts.FeeRate)
+		if needed <= fee {
+			return tx, nil
+		}
+		fee = needed
+	}
 }
 
-// NewTransaction create a new transaction. From, to are the given address.
-func NewTransaction(from, to string, amount int, UTXO *UTXOSet) *Transaction {
+// fundTransaction creates a transaction paying amount to the to address and fee to the miner from outputs locked to
+// lockHash, the change going to changeAddress, with the data output and the locks of opts. input creates the input
+// spending an output. The lock time of the transaction is raised to the lock times of the LockTimeScript outputs it
+// spends.
+func fundTransaction(lockHash []byte, changeAddress, to string, amount, fee int, opts TxOptions, UTXO *UTXOSet,
+	input func(txID []byte, out int) TxInput) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	wallets, _ := wallet.CreateWallets()
-	w := wallets.GetWallet(from)
-
-	acc, validOutputs := UTXO.FindSpendableOutputs(wallet.PublicKeyHash(w.PublicKey), amount)
+	acc, validOutputs, err := UTXO.FindSpendableOutputs(lockHash, amount+fee, opts.CoinSelector)
+	if err != nil {
+		return nil, err
+	}
 
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %d available, %d needed", ErrInsufficientFunds, acc, amount+fee)
 	}
 
+	lockTime := opts.LockTime
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
-		Handle(err)
+		if err != nil {
+			return nil, err
+		}
 
 		// create input for each unspent outputs.
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+			inputs = append(inputs, input(txID, out))
+
+			spent, _, err := UTXO.FindOutput(txID, out)
+			if err != nil {
+				return nil, err
+			}
+			locked, _, ok := lockTimeScript(spent.Script)
+			if !ok {
+				continue
+			}
+			if lockTime != 0 && (locked < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+				return nil, fmt.Errorf("lock times %d and %d are not both heights or both times", locked, lockTime)
+			}
+			if locked > lockTime {
+				lockTime = locked
+			}
 		}
 	}
 
-	outputs = append(outputs, *NewTxOutput(amount, to))
+	payment := NewTxOutput(amount, to)
+	if opts.LockOutput != 0 {
+		if payment.Version == wallet.MultisigVersion {
+			return nil, fmt.Errorf("%w: a multisig address cannot be locked until %d", ErrNonStandardScript,
+				opts.LockOutput)
+		}
+		if opts.LockOutput < 0 || len(encodeNum(opts.LockOutput)) > maxNumSize {
+			return nil, fmt.Errorf("%w: lock time %d out of range", ErrNonStandardScript, opts.LockOutput)
+		}
+		payment.Script = LockTimeScript(opts.LockOutput, payment.PubKeyHash)
+	}
+	outputs = append(outputs, *payment)
+	if opts.Data != nil {
+		outputs = append(outputs, *NewDataOutput(opts.Data))
+	}
 
 	// If there is any left over create a new output with the change for from.
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTxOutput(acc-amount-fee, changeAddress))
 	}
 
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTx(&tx, &w.PrivateKey)
+	for i := range inputs {
+		inputs[i].Sequence = opts.Sequence
+	}
 
-	return &tx
+	tx := Transaction{nil, inputs, outputs, lockTime}
+	tx.SetID()
+
+	return &tx, nil
 }
 
 // CoinBaseTx is a special transaction that get stored in genesis block.
 // This transaction will have only one input and only one output.
 // It doesn't store signature, but it stores arbitrary data.
-// This transaction is a rewarded to who did mining to it.
-func CoinBaseTx(to, data string) *Transaction {
+// This transaction is a rewarded to who did mining to it, value being at most the subsidy of Params.GetBlockSubsidy
+// plus the fees of the block.
+func CoinBaseTx(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
-		_, err := rand.Read(randData)
-		Handle(err)
+		// crypto/rand never fails on the supported platforms.
+		_, _ = rand.Read(randData)
 
 		data = fmt.Sprintf("%x", randData)
 	}
 
 	// out is -1 because it references no output
-	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	// 100 is a reward of 100 tokens.
-	txOut := NewTxOutput(20, to)
+	txIn := TxInput{ID: []byte{}, Out: -1, PubKey: []byte(data)}
+	txOut := NewTxOutput(value, to)
 
-	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
+	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}, 0}
 	tx.SetID()
 
 	return &tx
 }
 
+// SetExtraNonce stores the extra nonce in the coinbase input, which has nothing to sign, and recomputes the ID.
+// Miners change it to get a new merkle root once every nonce of the header was tried.
+func (tx *Transaction) SetExtraNonce(extraNonce int64) {
+	tx.Inputs[0].Signature = ToHex(extraNonce)
+	tx.SetID()
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-// Sign use the sign the input as it has the reference for the output.
-func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]*Transaction) {
+// Sign use the sign the input as it has the reference for the output. An input spending a multisig output gets
+// the signature in the slot of the public key of privKey, ErrNotCosigner is returned when the key is not one of
+// the multisig. An output locked by a Script can only be signed for when it is a PayToPubKeyHashScript or a
+// LockTimeScript.
+func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]*Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
 	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+		prevTx, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok || prevTx.ID == nil {
+			return fmt.Errorf("%w: %x", ErrTxNotFound, in.ID)
+		}
+		if in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return fmt.Errorf("%w: %x:%d", ErrMissingInput, in.ID, in.Out)
 		}
 	}
 
-	//txCopy := *tx
-
+	pubKey := wallet.PublicKeyBytes(&privKey.PublicKey)
 	txCopy := tx.TrimmedCopy()
 
 	for inId, in := range tx.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r, s, err := ecdsa.Sign(rand.Reader, privKey, txCopy.ID)
-		Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+
+		if prevOut.Script != nil {
+			_, payToKey := payToPubKeyHash(prevOut.Script)
+			if _, _, locked := lockTimeScript(prevOut.Script); !payToKey && !locked {
+				return fmt.Errorf("%w: input %d spends %s", ErrNonStandardScript, inId,
+					DisassembleScript(prevOut.Script))
+			}
+		}
 
-		tx.Inputs[inId].Signature = signature
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, txCopy.signatureHash(inId, prevOut))
+		if err != nil {
+			return err
+		}
+		signature := make([]byte, 2*coordinateSize)
+		r.FillBytes(signature[:coordinateSize])
+		s.FillBytes(signature[coordinateSize:])
+
+		switch {
+		case prevOut.Script != nil:
+			tx.Inputs[inId].ScriptSig = PushData(PushData(nil, signature), pubKey)
+		case in.Redeem != nil:
+			ms, err := wallet.DeserializeMultisig(in.Redeem)
+			if err != nil {
+				return err
+			}
+			slot := ms.KeyIndex(pubKey)
+			if slot < 0 || slot >= len(in.Signatures) {
+				return fmt.Errorf("%w: input %d", ErrNotCosigner, inId)
+			}
+			tx.Inputs[inId].Signatures[slot] = signature
+		default:
+			tx.Inputs[inId].Signature = signature
+		}
 	}
+
+	return nil
 }
 
+// Verify runs the unlocking script of every input and the locking script of the output it spends.
 func (tx *Transaction) Verify(prevTXs map[string]*Transaction) bool {
+	return tx.VerifyScripts(prevTXs) == nil
+}
+
+// VerifyScripts is Verify returning why the first failing input fails.
+func (tx *Transaction) VerifyScripts(prevTXs map[string]*Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
 	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction not correct")
+		prevTx, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok || prevTx.ID == nil {
+			return fmt.Errorf("%w: %x", ErrTxNotFound, in.ID)
+		}
+		if in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return fmt.Errorf("%w: %x:%d", ErrMissingInput, in.ID, in.Out)
 		}
 	}
 
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
 
 	for inId, in := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+		prevOut := prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out]
+		hash := txCopy.signatureHash(inId, prevOut)
 
-		r := big.Int{}
-		s := big.Int{}
+		engine := scriptEngine{
+			checkSig: func(signature, pubKey []byte) bool { return verifySignature(pubKey, signature, hash) },
+			tx:       tx,
+			input:    inId,
+		}
+		if err := engine.verifyScripts(in.UnlockingScript(), prevOut.LockingScript()); err != nil {
+			return fmt.Errorf("input %d: %w", inId, err)
+		}
+	}
+
+	return nil
+}
+
+// signatureHash is the hash input inId signs: the trimmed transaction with the locking hash of the spent output, or
+// its Script, in place of the public key of the input.
+func (txCopy *Transaction) signatureHash(inId int, prevOut TxOutput) []byte {
+	txCopy.Inputs[inId].PubKey = prevOut.PubKeyHash
+	if prevOut.Script != nil {
+		txCopy.Inputs[inId].PubKey = prevOut.Script
+	}
+	hash := txCopy.Hash()
+	txCopy.Inputs[inId].PubKey = nil
+
+	return hash
+}
+
+func verifySignature(pubKey, signature, hash []byte) bool {
+	r := big.Int{}
+	s := big.Int{}
 
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
+
+// SignatureCount returns, for every input spending a multisig output, how many of its slots hold a signature.
+func (tx *Transaction) SignatureCount() []int {
+	var counts []int
+
+	for _, in := range tx.Inputs {
+		if in.Redeem == nil {
+			continue
 		}
+
+		signed := 0
+		for _, signature := range in.Signatures {
+			if len(signature) != 0 {
+				signed++
+			}
+		}
+		counts = append(counts, signed)
 	}
 
-	return true
+	return counts
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
@@ -199,14 +670,14 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{ID: in.ID, Out: in.Out, Sequence: in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash, out.Version, out.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
@@ -215,18 +686,27 @@ func (tx *Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", input.PubKey))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("       Script:    %s", DisassembleScript(input.UnlockingScript())))
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %#x", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisassembleScript(output.LockingScript())))
 	}
 
 	return strings.Join(lines, "\n")
@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+// TestTxIDPinned pins the IDs of transactions of the first release, computed with its code, and of a transaction
+// using later fields, so a change of hashEncoding cannot go unnoticed.
+func TestTxIDPinned(t *testing.T) {
+	owner := bytes.Repeat([]byte{0x11}, 20)
+
+	coinbase := &Transaction{
+		Inputs:  []TxInput{{ID: []byte{}, Out: -1, PubKey: []byte(genesisData)}},
+		Outputs: []TxOutput{{Value: 20, PubKeyHash: owner}},
+	}
+	coinbase.SetID()
+
+	spend := &Transaction{
+		Inputs: []TxInput{{ID: coinbase.ID, Out: 0, PubKey: bytes.Repeat([]byte{0x33}, 64)}},
+		Outputs: []TxOutput{
+			{Value: 15, PubKeyHash: bytes.Repeat([]byte{0x44}, 20)},
+			{Value: 5, PubKeyHash: owner},
+		},
+	}
+	spend.SetID()
+	spend.Inputs[0].Signature = bytes.Repeat([]byte{0x22}, 64)
+
+	locked := &Transaction{
+		Inputs:   []TxInput{{ID: coinbase.ID, Out: 0, PubKey: bytes.Repeat([]byte{0x33}, 64), Sequence: 1}},
+		Outputs:  []TxOutput{{Value: 20, PubKeyHash: owner}},
+		LockTime: 100,
+	}
+	locked.SetID()
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		want string
+	}{
+		{"first release coinbase", coinbase, "2658a7702fed71090e6a65898be12da3562a0be4f06450e610b0ec46243a2efc"},
+		{"first release spend", spend, "3d5be21beea20d8df504b85d7c7d7ec8307135e78ce019a0e6c5d70df25dbdfa"},
+		{"lock time and sequence", locked, "dc9d4d5a4c2ad7adb3b7d52ed87ccf7faa1c50d1ee034c4eae8682052ffb8edf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hex.EncodeToString(test.tx.ID); got != test.want {
+				t.Errorf("ID %s, want %s", got, test.want)
+			}
+			if got := hex.EncodeToString(test.tx.UnsignedHash()); got != test.want {
+				t.Errorf("unsigned hash %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestLegacyEncodingIgnoresGobState checks that the hash of a first release transaction does not depend on the types
+// the process gob encoded before, which gob numbers in the order it meets them.
+func TestLegacyEncodingIgnoresGobState(t *testing.T) {
+	type Unrelated struct {
+		Field []string
+	}
+	if err := gob.NewEncoder(io.Discard).Encode(Unrelated{[]string{"field"}}); err != nil {
+		t.Fatal(err)
+	}
+	(&Block{}).Serialize()
+
+	// the genesis coinbase of testdata/baseline-chain.txt, which locked its output to the address itself.
+	coinbase := &Transaction{
+		Inputs:  []TxInput{{ID: []byte{}, Out: -1, PubKey: []byte(genesisData)}},
+		Outputs: []TxOutput{{Value: 20, PubKeyHash: []byte("7ecx9TfzBtYGJ79jpSVe71J8nhUFvLFjkBPoC6sgiX")}},
+	}
+
+	want := "30946640d1fcb177b0be4dce3d8e67303677da1003902db41a2e67f5ec95782b"
+	if got := hex.EncodeToString(coinbase.Hash()); got != want {
+		t.Fatalf("hash %s, want %s", got, want)
+	}
+}
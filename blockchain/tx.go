@@ -3,6 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"github.com/tensor-programming/golang-blockchain/wallet"
 )
 
@@ -12,10 +13,27 @@ import (
 type TxOutput struct {
 	Value      int    // value in token
 	PubKeyHash []byte // public key is a value needed to unlock tokens that stored in value.
+	// Version is the version byte of the address the output pays. With wallet.MultisigVersion PubKeyHash is the
+	// hash of a multisig.
+	Version byte
+	// Script locks the output in place of PubKeyHash when set.
+	Script []byte
 }
 
+// TxOutputs are the unspent outputs of a transaction keyed by their index in that transaction.
 type TxOutputs struct {
-	Outputs []TxOutput
+	Outputs map[int]TxOutput
+}
+
+// NewTxOutputs indexes all the outputs of a transaction.
+func NewTxOutputs(outputs []TxOutput) TxOutputs {
+	outs := TxOutputs{make(map[int]TxOutput)}
+
+	for outIdx, out := range outputs {
+		outs.Outputs[outIdx] = out
+	}
+
+	return outs
 }
 
 // TxInput are just reference to given TxOutput
@@ -24,6 +42,14 @@ type TxInput struct {
 	Out       int    // it's the index where this output appears. So if you want to display ID with index 2 then the value will be 2.
 	Signature []byte // it's a signature to use for the pubKey. for now Signature and pub key are same value.
 	PubKey    []byte
+	// Redeem is the serialized wallet.Multisig an input spending a multisig output reveals, Signatures holding one
+	// slot per key of the multisig, nil for the keys that did not sign.
+	Redeem     []byte
+	Signatures [][]byte
+	// ScriptSig unlocks an output locked by a Script.
+	ScriptSig []byte
+	// Sequence holds the relative lock of the input, see SequenceLockTimeMask.
+	Sequence uint32
 }
 
 // NewTxOutput is a new command as caller will pass amount and the address.
@@ -34,9 +60,54 @@ func NewTxOutput(amount int, address string) *TxOutput {
 	return txOut
 }
 
+// NewDataOutput creates an unspendable output carrying data.
+func NewDataOutput(data []byte) *TxOutput {
+	return &TxOutput{Script: DataScript(data)}
+}
+
+// LockingScript is the script the output is locked by: Script when set, else the standard script of the address
+// the output pays.
+func (out *TxOutput) LockingScript() []byte {
+	switch {
+	case out.Script != nil:
+		return out.Script
+	case out.Version == wallet.MultisigVersion:
+		return PayToMultisigHashScript(out.PubKeyHash)
+	}
+
+	return PayToPubKeyHashScript(out.PubKeyHash)
+}
+
+// IsUnspendable reports whether the output is a data output no script can unlock.
+func (out *TxOutput) IsUnspendable() bool {
+	return len(out.Script) > 0 && out.Script[0] == OpReturn
+}
+
+// UnlockingScript is the script the input unlocks its output with: ScriptSig when set, else the pushes of the
+// signature and the public key, or of the multisig signature slots and the multisig for multisig inputs.
+func (in *TxInput) UnlockingScript() []byte {
+	if in.ScriptSig != nil {
+		return in.ScriptSig
+	}
+
+	var script []byte
+	if in.Redeem != nil {
+		for _, signature := range in.Signatures {
+			script = PushData(script, signature)
+		}
+		return PushData(script, in.Redeem)
+	}
+
+	script = PushData(script, in.Signature)
+	return PushData(script, in.PubKey)
+}
+
 // UsesKey is validation to check if tx input could be unlocked.
 func (in *TxInput) UsesKey(pubKeyHas []byte) bool {
 	lockingHash := wallet.PublicKeyHash(in.PubKey)
+	if in.Redeem != nil {
+		lockingHash = wallet.PublicKeyHash(in.Redeem)
+	}
 
 	return bytes.Equal(lockingHash, pubKeyHas)
 }
@@ -44,10 +115,23 @@ func (in *TxInput) UsesKey(pubKeyHas []byte) bool {
 // Lock set publicKeyHashed after trimming version, and checksum.
 func (out *TxOutput) Lock(address []byte) {
 	out.PubKeyHash = PubKeyHash(address)
+	if wallet.Base58Decode(address)[0] == wallet.MultisigVersion {
+		out.Version = wallet.MultisigVersion
+	}
+}
+
+// Address is the address the output pays, for the outputs without a Script.
+func (out *TxOutput) Address() []byte {
+	if out.Version == wallet.MultisigVersion {
+		return wallet.MultisigHashToAddress(out.PubKeyHash)
+	}
+
+	return wallet.PubKeyHashToAddress(out.PubKeyHash)
 }
 
+// PubKeyHash decodes the address and strips its version byte and checksum.
 func PubKeyHash(address []byte) []byte {
-	pubKeyHash := wallet.Base58Encode(address)
+	pubKeyHash := wallet.Base58Decode(address)
 	return pubKeyHash[1 : len(pubKeyHash)-4]
 }
 
@@ -64,17 +148,18 @@ func (outs TxOutputs) Serialize() []byte {
 
 	encode := gob.NewEncoder(&buffer)
 	err := encode.Encode(outs)
-	Handle(err)
+	mustEncode(err)
 
 	return buffer.Bytes()
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
 	var outputs TxOutputs
 
 	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	Handle(err)
+	if err := decode.Decode(&outputs); err != nil {
+		return TxOutputs{}, fmt.Errorf("decoding outputs: %w", err)
+	}
 
-	return outputs
+	return outputs, nil
 }
@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
+)
+
+var (
+	txIndexPrefix = []byte("tx-")
+	// txIndexFlag is set once the transaction index covers the main chain. From then on it is kept up to date as
+	// blocks are connected and disconnected.
+	txIndexFlag = []byte("txindex")
+)
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// txLocation is the entry of the transaction index: the block holding a transaction and its position in the block.
+func txLocation(blockHash []byte, position int) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, blockHash...), uint32(position))
+}
+
+func parseTxLocation(location []byte) ([]byte, int, error) {
+	if len(location) < 4 {
+		return nil, 0, fmt.Errorf("malformed transaction index entry %x", location)
+	}
+	split := len(location) - 4
+
+	return location[:split], int(binary.BigEndian.Uint32(location[split:])), nil
+}
+
+func txIndexed(txn storage.Reader) (bool, error) {
+	_, err := txn.Get(txIndexFlag)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// indexTxs adds the transactions of a main chain block to the transaction index, when the chain has one.
+func indexTxs(txn storage.Txn, block *Block) error {
+	indexed, err := txIndexed(txn)
+	if err != nil || !indexed {
+		return err
+	}
+
+	return setTxLocations(txn, block)
+}
+
+func setTxLocations(txn storage.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		if err := txn.Put(txIndexKey(tx.ID), txLocation(block.Hash, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTxs removes the transactions of a block leaving the main chain from the transaction index.
+func unindexTxs(txn storage.Txn, block *Block) error {
+	indexed, err := txIndexed(txn)
+	if err != nil || !indexed {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasTxIndex reports whether the chain keeps a transaction index.
+func (chain *BlockChain) HasTxIndex() (bool, error) {
+	return txIndexed(chain.Database)
+}
+
+// ReindexTxs rebuilds the transaction index from the main chain and keeps it up to date from then on. It returns
+// the number of transactions indexed.
+func (chain *BlockChain) ReindexTxs() (int, error) {
+	if err := chain.Database.Delete(txIndexFlag); err != nil {
+		return 0, err
+	}
+
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.DeleteByPrefix(txIndexPrefix); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		err = chain.Database.Update(func(txn storage.Txn) error {
+			return setTxLocations(txn, block)
+		})
+		if err != nil {
+			return 0, err
+		}
+		count += len(block.Transactions)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return count, chain.Database.Put(txIndexFlag, []byte{})
+}
+
+// lookupTx finds a transaction with the transaction index. indexed is false when the chain has no index, the
+// transaction then has to be searched for in the blocks.
+func (chain *BlockChain) lookupTx(ID []byte) (tx *Transaction, block *Block, indexed bool, err error) {
+	var location []byte
+
+	err = chain.Database.View(func(txn storage.Reader) error {
+		// entries left behind by an index that was dropped or only partly rebuilt are not trusted.
+		indexed, err = txIndexed(txn)
+		if err != nil || !indexed {
+			return err
+		}
+
+		location, err = txn.Get(txIndexKey(ID))
+		if err == storage.ErrNotFound {
+			return fmt.Errorf("%w: %x", ErrTxNotFound, ID)
+		}
+
+		return err
+	})
+	if err != nil || location == nil {
+		return nil, nil, indexed, err
+	}
+
+	blockHash, position, err := parseTxLocation(location)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	found, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if position >= len(found.Transactions) {
+		return nil, nil, true, fmt.Errorf("transaction index entry of %x is past the end of block %x", ID, blockHash)
+	}
+
+	return found.Transactions[position], &found, true, nil
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// chainWithTxs builds a chain of blocks on top of genesis holding a coinbase each, with or without the transaction
+// index. It returns the genesis block.
+func chainWithTxs(tb testing.TB, blocks int, txIndex bool) (*BlockChain, *Block) {
+	tb.Helper()
+
+	w := wallet.MakeWallet()
+	chain := newTestChain(tb, w, Options{TxIndex: txIndex})
+	genesis := tip(tb, chain)
+	for i := 0; i < blocks; i++ {
+		addBlock(tb, chain, w)
+	}
+
+	return chain, genesis
+}
+
+func TestFindTxBlock(t *testing.T) {
+	for _, txIndex := range []bool{false, true} {
+		chain, genesis := chainWithTxs(t, 5, txIndex)
+		last := tip(t, chain)
+
+		for _, block := range []*Block{genesis, last} {
+			tx, found, err := chain.FindTxBlock(block.Transactions[0].ID)
+			if err != nil {
+				t.Fatalf("index %v: %v", txIndex, err)
+			}
+			if !bytes.Equal(tx.ID, block.Transactions[0].ID) || !bytes.Equal(found.Hash, block.Hash) {
+				t.Errorf("index %v: found %x in %x, want %x in %x", txIndex, tx.ID, found.Hash,
+					block.Transactions[0].ID, block.Hash)
+			}
+		}
+
+		if _, _, err := chain.FindTxBlock(make([]byte, 32)); !errors.Is(err, ErrTxNotFound) {
+			t.Errorf("index %v: FindTxBlock = %v, want %v", txIndex, err, ErrTxNotFound)
+		}
+	}
+}
+
+func TestFindTxBlockIgnoresStaleIndex(t *testing.T) {
+	chain, genesis := chainWithTxs(t, 3, false)
+	last := tip(t, chain)
+	ID := genesis.Transactions[0].ID
+
+	// an entry left behind without the txindex flag, pointing at the wrong block.
+	if err := chain.Database.Put(txIndexKey(ID), txLocation(last.Hash, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, found, err := chain.FindTxBlock(ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tx.ID, ID) || !bytes.Equal(found.Hash, genesis.Hash) {
+		t.Errorf("found %x in %x, want %x in %x", tx.ID, found.Hash, ID, genesis.Hash)
+	}
+}
+
+// BenchmarkFindTx looks up the genesis coinbase, the worst case of walking back from the tip.
+func BenchmarkFindTx(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		txIndex bool
+	}{
+		{"scan", false},
+		{"index", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			chain, genesis := chainWithTxs(b, 200, bench.txIndex)
+			ID := genesis.Transactions[0].ID
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := chain.FindTx(ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,55 +2,103 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/hex"
-	"github.com/dgraph-io/badger"
-	"log"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tensor-programming/golang-blockchain/storage"
 )
 
 var (
 	utxoPrefix   = []byte("utxo-")
 	prefixLength = len(utxoPrefix)
+	undoPrefix   = []byte("undo-")
 )
 
+// BlockUndo holds the outputs a block spent, in the order of the inputs of its transactions.
+type BlockUndo struct {
+	Spent []TxOutput
+}
+
+func (undo BlockUndo) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(undo)
+	mustEncode(err)
+
+	return buffer.Bytes()
+}
+
+func DeserializeUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	if err := decode.Decode(&undo); err != nil {
+		return BlockUndo{}, fmt.Errorf("decoding undo record: %w", err)
+	}
+
+	return undo, nil
+}
+
+func undoKey(blockHash []byte) []byte {
+	key := make([]byte, 0, len(undoPrefix)+len(blockHash))
+	key = append(key, undoPrefix...)
+
+	return append(key, blockHash...)
+}
+
+// utxoKey builds the key of a transaction outputs without sharing the backing array of utxoPrefix.
+func utxoKey(txID []byte) []byte {
+	key := make([]byte, 0, prefixLength+len(txID))
+	key = append(key, utxoPrefix...)
+
+	return append(key, txID...)
+}
+
 // UTXOSet unspent tx out set.
 type UTXOSet struct {
 	Blockchain *BlockChain // The only reason is here is to access the DB.
 }
 
-func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	accumulated := 0
-	db := u.Blockchain.Database
+// FindSpendableOutputs picks outputs locked to pubKeyHash worth at least amount with the selector, LargestFirst when
+// nil, leaving out the LockTimeScript outputs the next block cannot spend yet. It returns their value and their
+// indexes by hex transaction ID. The value is below amount when every output of pubKeyHash is worth less.
+func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int, selector CoinSelector) (int, map[string][]int, error) {
+	unspent, err := u.ListUnspent(pubKeyHash)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.ValueCopy(nil)
-			Handle(err)
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-
-			outs := DeserializeOutputs(v)
-
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithHash(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], out.Value)
-				}
-			}
+	height, medianTime, err := u.Blockchain.nextBlockLocks()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var candidates []UnspentOutput
+	for _, out := range unspent {
+		if lockTime, _, ok := lockTimeScript(out.Output.Script); ok && !lockTimePassed(lockTime, height, medianTime) {
+			continue
 		}
+		candidates = append(candidates, out)
+	}
 
-		return nil
-	})
+	if selector == nil {
+		selector = LargestFirst{}
+	}
 
-	Handle(err)
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
 
-	return accumulated, unspentOutputs
+	for _, out := range selector.Select(candidates, amount) {
+		txID := hex.EncodeToString(out.TxID)
+		unspentOutputs[txID] = append(unspentOutputs[txID], out.Index)
+		accumulated += out.Output.Value
+	}
+
+	return accumulated, unspentOutputs, nil
 }
 
 //func (u *UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
@@ -84,126 +132,336 @@ func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[
 //	return UTXOs
 //}
 
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
 
 	db := u.Blockchain.Database
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := db.Iterate(utxoPrefix, func(_, v []byte) error {
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return err
+		}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+		for _, out := range outs.Outputs {
+			if out.IsLockedWithHash(pubKeyHash) {
+				UTXOs = append(UTXOs, out)
+			}
+		}
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.ValueCopy(nil)
-			Handle(err)
-			outs := DeserializeOutputs(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithHash(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
+	return UTXOs, nil
+}
+
+// UnspentOutput is an output of the UTXO set with the outpoint it is found at.
+type UnspentOutput struct {
+	TxID   []byte
+	Index  int
+	Output TxOutput
+}
+
+// ListUnspent returns every unspent output locked to the public key hash, ordered by transaction ID and index.
+func (u *UTXOSet) ListUnspent(pubKeyHash []byte) ([]UnspentOutput, error) {
+	var unspent []UnspentOutput
+
+	err := u.Blockchain.Database.Iterate(utxoPrefix, func(key, v []byte) error {
+		txID := bytes.TrimPrefix(key, utxoPrefix)
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return err
+		}
+
+		var indexes []int
+		for outIdx, out := range outs.Outputs {
+			if out.IsLockedWithHash(pubKeyHash) {
+				indexes = append(indexes, outIdx)
 			}
 		}
+		sort.Ints(indexes)
+
+		for _, outIdx := range indexes {
+			unspent = append(unspent, UnspentOutput{txID, outIdx, outs.Outputs[outIdx]})
+		}
 
 		return nil
 	})
-	Handle(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return unspent, nil
+}
 
-	return UTXOs
+// FindOutput looks up a single unspent output. It returns false when the output was spent or never existed.
+func (u *UTXOSet) FindOutput(txID []byte, outIdx int) (TxOutput, bool, error) {
+	v, err := u.Blockchain.Database.Get(utxoKey(txID))
+	if err == storage.ErrNotFound {
+		return TxOutput{}, false, nil
+	}
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+
+	outs, err := DeserializeOutputs(v)
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+	output, found := outs.Outputs[outIdx]
+
+	return output, found, nil
 }
 
-func (u *UTXOSet) CountTransactions() int {
+func (u *UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.Database
 	counter := 0
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := db.Iterate(utxoPrefix, func(_, _ []byte) error {
+		counter++
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
+
+// TotalValue sums the values of the unspent outputs, the coins in circulation.
+func (u *UTXOSet) TotalValue() (int, error) {
+	total := 0
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
+	err := u.Blockchain.Database.Iterate(utxoPrefix, func(_, v []byte) error {
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return err
+		}
+
+		for _, out := range outs.Outputs {
+			total += out.Value
 		}
 
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
 
-	Handle(err)
-
-	return counter
+	return total, nil
 }
 
-func (u *UTXOSet) Reindex() {
+// Reindex rebuilds the UTXO set from the whole main chain.
+func (u *UTXOSet) Reindex() error {
 	db := u.Blockchain.Database
 
-	u.DeleteByPrefix(utxoPrefix)
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
 
-	UTXO := u.Blockchain.FindUTXO()
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
+	return db.Update(func(txn storage.Txn) error {
 		for txId, outs := range UTXO {
 			key, err := hex.DecodeString(txId)
 			if err != nil {
 				return err
 			}
-			key = append(utxoPrefix, key...)
+			key = utxoKey(key)
 
-			err = txn.Set(key, outs.Serialize())
-			Handle(err)
+			if err := txn.Put(key, outs.Serialize()); err != nil {
+				return err
+			}
 		}
 
 		return nil
 	})
-	Handle(err)
+}
+
+// errStopIteration ends an iteration of the store early.
+var errStopIteration = errors.New("stop iteration")
+
+// hasLegacyLayout reports whether the UTXO set was stored by the first release. Its TxOutputs kept the unspent
+// outputs in a slice, which loses the indexes of the outputs once one is spent, so the set cannot be converted and
+// has to be rebuilt with Reindex.
+func (u *UTXOSet) hasLegacyLayout() (bool, error) {
+	legacy := false
+
+	err := u.Blockchain.Database.Iterate(utxoPrefix, func(_, v []byte) error {
+		if _, err := DeserializeOutputs(v); err == nil {
+			return errStopIteration
+		}
+
+		var outs struct {
+			Outputs []TxOutput
+		}
+		if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&outs); err != nil {
+			return fmt.Errorf("decoding outputs: %w", err)
+		}
+		legacy = true
+
+		return errStopIteration
+	})
+	if err != nil && err != errStopIteration {
+		return false, err
+	}
+
+	return legacy, nil
 }
 
 // Update db by iterating inputs ID which is txID and store all serialized unspent outputs.
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
+// Outputs created by the block transactions are added to the set. The spent outputs are kept in the undo record of
+// the block so Revert can restore them. ConnectBlock already does this for the blocks it connects.
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn storage.Txn) error {
+		return updateUTXO(txn, block)
+	})
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					v, err := item.ValueCopy(nil)
-					Handle(err)
-					outs := DeserializeOutputs(v)
-					for outIDx, out := range outs.Outputs {
-						if outIDx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+// updateUTXO is Update in the transaction txn.
+func updateUTXO(txn storage.Txn, block *Block) error {
+	var undo BlockUndo
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				inID := utxoKey(in.ID)
+				v, err := txn.Get(inID)
+				if err != nil {
+					return fmt.Errorf("output %x:%d: %w", in.ID, in.Out, err)
+				}
+				updatedOuts, err := DeserializeOutputs(v)
+				if err != nil {
+					return err
+				}
+				undo.Spent = append(undo.Spent, updatedOuts.Outputs[in.Out])
+				delete(updatedOuts.Outputs, in.Out)
 
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
+				if len(updatedOuts.Outputs) == 0 {
+					if err := txn.Delete(inID); err != nil {
+						return err
+					}
 
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
+				} else {
+					if err := txn.Put(inID, updatedOuts.Serialize()); err != nil {
+						return err
 					}
 				}
 			}
 		}
 
-		return nil
+		newOutputs := NewTxOutputs(tx.Outputs)
+
+		txID := utxoKey(tx.ID)
+		if _, err := txn.Get(txID); err == nil {
+			return fmt.Errorf("%w: %x", ErrTxIDInUse, tx.ID)
+		} else if err != storage.ErrNotFound {
+			return err
+		}
+		if err := txn.Put(txID, newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return txn.Put(undoKey(block.Hash), undo.Serialize())
+}
+
+// Revert undoes Update for a block that is the current tip: the outputs its transactions created are removed and
+// the outputs they spent are restored from the undo record of the block, which is then dropped. Transactions are
+// undone in reverse order so outputs spent inside the block are handled too.
+func (u *UTXOSet) Revert(block *Block) error {
+	undo, err := u.blockUndo(block)
+	if err != nil {
+		return err
+	}
+
+	return u.Blockchain.Database.Update(func(txn storage.Txn) error {
+		return revertUTXO(txn, block, undo)
 	})
+}
+
+// revertUTXO is Revert in the transaction txn, with the undo record of the block.
+func revertUTXO(txn storage.Txn, block *Block, undo BlockUndo) error {
+	spent := len(undo.Spent)
 
-	Handle(err)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		if err := txn.Delete(utxoKey(tx.ID)); err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			in := tx.Inputs[j]
+			inID := utxoKey(in.ID)
+			outs := NewTxOutputs(nil)
+
+			v, err := txn.Get(inID)
+			if err == nil {
+				if outs, err = DeserializeOutputs(v); err != nil {
+					return err
+				}
+			} else if err != storage.ErrNotFound {
+				return err
+			}
+
+			if spent == 0 {
+				return fmt.Errorf("undo record of block %x is too short", block.Hash)
+			}
+			spent--
+			outs.Outputs[in.Out] = undo.Spent[spent]
+			if err := txn.Put(inID, outs.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return txn.Delete(undoKey(block.Hash))
 }
 
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+// blockUndo reads the undo record of a block. Blocks connected before undo records were kept get it rebuilt from the
+// transactions they spend, which must still be on the main chain.
+func (u *UTXOSet) blockUndo(block *Block) (BlockUndo, error) {
+	v, err := u.Blockchain.Database.Get(undoKey(block.Hash))
+	if err == nil {
+		return DeserializeUndo(v)
+	}
+	if err != storage.ErrNotFound {
+		return BlockUndo{}, err
+	}
+
+	var undo BlockUndo
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			prevTx, err := u.Blockchain.FindTx(in.ID)
+			if err != nil {
+				return BlockUndo{}, fmt.Errorf("reverting block %x: %w", block.Hash, err)
+			}
+			undo.Spent = append(undo.Spent, prevTx.Outputs[in.Out])
+		}
+	}
+
+	return undo, nil
+}
+
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
 	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		if err := u.Blockchain.Database.Update(func(txn storage.Txn) error {
 			for _, key := range keysForDelete {
 				if err := txn.Delete(key); err != nil {
 					return err
@@ -217,32 +475,25 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 	}
 
 	collectSize := 100000
-	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
-				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
-			}
-		}
-		if keysCollected > 0 {
+	keysForDelete := make([][]byte, 0, collectSize)
+	keysCollected := 0
+	err := u.Blockchain.Database.Iterate(prefix, func(key, _ []byte) error {
+		keysForDelete = append(keysForDelete, key)
+		keysCollected++
+		if keysCollected == collectSize {
 			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
+				return err
 			}
+			keysForDelete = make([][]byte, 0, collectSize)
+			keysCollected = 0
 		}
 		return nil
 	})
-	Handle(err)
+	if err != nil {
+		return err
+	}
+	if keysCollected > 0 {
+		return deleteKeys(keysForDelete)
+	}
+	return nil
 }
@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// spend signs a transaction spending output out of prev, locked to from, into the outputs.
+func spend(t *testing.T, from *wallet.Wallet, prev *Transaction, out int, outputs ...TxOutput) *Transaction {
+	t.Helper()
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: prev.ID, Out: out, PubKey: from.PublicKey}},
+		Outputs: outputs,
+	}
+	tx.SetID()
+
+	if err := tx.Sign(&from.PrivateKey, map[string]*Transaction{fmt.Sprintf("%x", prev.ID): prev}); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+// undoChain connects a block splitting the genesis coinbase between alice and w, and mines without connecting a
+// block in which alice spends her part to bob, who spends it back in the same block. The output of w stays
+// unspent, so the entry of the split is only partly spent.
+func undoChain(t *testing.T) (*BlockChain, *Transaction, *Block) {
+	t.Helper()
+
+	w := wallet.MakeWallet()
+	alice := wallet.MakeWallet()
+	bob := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+
+	split := spend(t, w, tip(t, chain).Transactions[0], 0,
+		*NewTxOutput(10, string(alice.Address())), *NewTxOutput(10, string(w.Address())))
+	addBlock(t, chain, w, split)
+
+	toBob := spend(t, alice, split, 0, *NewTxOutput(10, string(bob.Address())))
+	back := spend(t, bob, toBob, 0, *NewTxOutput(9, string(alice.Address())))
+
+	return chain, split, mineOn(t, chain, tip(t, chain), w, toBob, back)
+}
+
+func TestUpdateRevert(t *testing.T) {
+	chain, split, block := undoChain(t)
+	UTXOSet := UTXOSet{chain}
+	before := dumpPrefix(t, chain, utxoPrefix)
+
+	if err := UTXOSet.Update(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := UTXOSet.FindOutput(split.ID, 0); err != nil || found {
+		t.Errorf("the spent output of the split is still unspent: %v", err)
+	}
+	if _, found, err := UTXOSet.FindOutput(split.ID, 1); err != nil || !found {
+		t.Errorf("the unspent output of the split is gone: %v", err)
+	}
+	if _, found, err := UTXOSet.FindOutput(block.Transactions[1].ID, 0); err != nil || found {
+		t.Errorf("the output spent in its own block is unspent: %v", err)
+	}
+
+	if err := UTXOSet.Revert(block); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpPrefix(t, chain, utxoPrefix); after != before {
+		t.Errorf("UTXO set after Revert\n%s\nwant\n%s", after, before)
+	}
+	if _, err := chain.Database.Get(undoKey(block.Hash)); err == nil {
+		t.Error("the undo record is kept after Revert")
+	}
+}
+
+func TestRevertWithoutUndoRecord(t *testing.T) {
+	chain, _, block := undoChain(t)
+	UTXOSet := UTXOSet{chain}
+	before := dumpPrefix(t, chain, utxoPrefix)
+
+	// a block connected before undo records were kept, the spent outputs are found on the main chain.
+	if err := chain.ConnectBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Database.Delete(undoKey(block.Hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UTXOSet.Revert(block); err != nil {
+		t.Fatal(err)
+	}
+	if after := dumpPrefix(t, chain, utxoPrefix); after != before {
+		t.Errorf("UTXO set after Revert\n%s\nwant\n%s", after, before)
+	}
+}
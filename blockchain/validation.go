@@ -0,0 +1,402 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// MaxFutureBlockTime is how far ahead of the local clock a block timestamp may be.
+	MaxFutureBlockTime = 2 * time.Hour
+	// medianTimeBlocks is the number of blocks the median time past is computed over.
+	medianTimeBlocks = 11
+	// MaxMoney bounds every value and every sum of values: far above the supply of any network, far enough below
+	// the int range that adding two valid amounts cannot overflow.
+	MaxMoney = 1 << 50
+)
+
+var (
+	ErrBadProofOfWork = errors.New("block hash does not satisfy its target")
+	ErrBadMerkleRoot  = errors.New("merkle root does not match the transactions")
+	ErrBadWitnessRoot = errors.New("witness root does not match the transactions")
+	ErrBadHeight      = errors.New("block height does not follow its parent")
+	ErrBadVersion     = errors.New("block version is not supported")
+	ErrBadBits        = errors.New("block target does not match the retargeting rules")
+	ErrUnknownParent  = errors.New("parent block is unknown")
+	ErrTimeTooOld     = errors.New("block timestamp is not after the median time past")
+	ErrTimeTooNew     = errors.New("block timestamp is too far in the future")
+)
+
+// MedianTimePast returns the median timestamp of the block with the given hash and of its ancestors, over the
+// last medianTimeBlocks blocks. A new block must have a timestamp strictly greater than the median of its parent.
+func (chain *BlockChain) MedianTimePast(blockHash []byte) (int64, error) {
+	var timestamps []int64
+
+	hash := blockHash
+	for len(timestamps) < medianTimeBlocks && len(hash) != 0 {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		timestamps = append(timestamps, block.Timestamp)
+		hash = block.PrevHash
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// CheckBlockHeader validates a block header against its parent and the local clock: version, proof of work, merkle
+// and witness roots, height, target bits and timestamp bounds. The genesis block has no parent, besides the context
+// free checks it must have the genesis target of the params. Only a genesis block may have the header of the first
+// release, and a block may not have a lower version than its parent.
+func (chain *BlockChain) CheckBlockHeader(block *Block, now time.Time) error {
+	if block.Version < 0 || block.Version > BlockVersion || (block.Version == 0 && len(block.PrevHash) != 0) {
+		return fmt.Errorf("%w: %d", ErrBadVersion, block.Version)
+	}
+
+	if !NewProof(block).Validate() {
+		return fmt.Errorf("%w: %x", ErrBadProofOfWork, block.Hash)
+	}
+
+	// the proof of work of the first release covers the transaction IDs directly.
+	if block.Version > 0 && !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return fmt.Errorf("%w: %x", ErrBadMerkleRoot, block.Hash)
+	}
+
+	if block.Version >= 2 && !bytes.Equal(block.WitnessRoot, block.HashWitnesses()) {
+		return fmt.Errorf("%w: %x", ErrBadWitnessRoot, block.Hash)
+	}
+
+	if block.Timestamp > now.Add(MaxFutureBlockTime).Unix() {
+		return fmt.Errorf("%w: %s", ErrTimeTooNew, time.Unix(block.Timestamp, 0))
+	}
+
+	if len(block.PrevHash) == 0 {
+		if block.Height != 0 {
+			return fmt.Errorf("%w: genesis at height %d", ErrBadHeight, block.Height)
+		}
+		if genesisBits := chain.params().GenesisBits; block.Bits != genesisBits {
+			return fmt.Errorf("%w: genesis got %08x, expected %08x", ErrBadBits, block.Bits, genesisBits)
+		}
+		return nil
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return fmt.Errorf("%w: %x", ErrUnknownParent, block.PrevHash)
+	}
+
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("%w: %d after %d", ErrBadHeight, block.Height, parent.Height)
+	}
+
+	if block.Version < parent.Version {
+		return fmt.Errorf("%w: %d after %d", ErrBadVersion, block.Version, parent.Version)
+	}
+
+	bits, err := chain.NextBits(&parent)
+	if err != nil {
+		return err
+	}
+
+	if block.Bits != bits {
+		return fmt.Errorf("%w: got %08x, expected %08x", ErrBadBits, block.Bits, bits)
+	}
+
+	medianTime, err := chain.MedianTimePast(parent.Hash)
+	if err != nil {
+		return err
+	}
+
+	if block.Timestamp <= medianTime {
+		return fmt.Errorf("%w: %s is not after %s", ErrTimeTooOld, time.Unix(block.Timestamp, 0),
+			time.Unix(medianTime, 0))
+	}
+
+	return nil
+}
+
+var (
+	ErrBadLink          = errors.New("block is not stored under its own hash")
+	ErrNoCoinbase       = errors.New("block does not start with a coinbase")
+	ErrMultipleCoinbase = errors.New("block has more than one coinbase")
+	ErrMissingInput     = errors.New("input references an output that does not exist")
+	ErrSpentInput       = errors.New("input references an output that is already spent")
+	ErrBadSignature     = errors.New("input script does not verify")
+	ErrBadValue         = errors.New("output value is not positive")
+	ErrValueImbalance   = errors.New("outputs are worth more than the inputs")
+	ErrValueOutOfRange  = errors.New("value is above the maximum amount")
+	ErrBadCoinbaseValue = errors.New("coinbase claims more than the subsidy and the fees")
+	ErrBadTxID          = errors.New("transaction ID is not the hash of the unsigned transaction")
+	ErrDuplicateTx      = errors.New("block holds the same transaction twice")
+	ErrTxIDInUse        = errors.New("transaction ID still has unspent outputs")
+)
+
+// ValidationError reports the first block, and transaction when TxID is set, that breaks a consensus rule.
+// Err wraps one of the rule errors above so callers can match it with errors.Is.
+type ValidationError struct {
+	BlockHash []byte
+	Height    int
+	TxID      []byte
+	Err       error
+}
+
+func (e *ValidationError) Error() string {
+	if e.TxID != nil {
+		return fmt.Sprintf("block %x at height %d, transaction %x: %v", e.BlockHash, e.Height, e.TxID, e.Err)
+	}
+
+	return fmt.Sprintf("block %x at height %d: %v", e.BlockHash, e.Height, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Verify replays the main chain from genesis to the tip. Every header is checked with CheckBlockHeader, every block
+// must hold exactly one coinbase claiming at most the subsidy plus the fees, and every transaction must spend existing unspent outputs with valid signatures
+// without creating value, once its locks allow it. The UTXO set is rebuilt in memory along the way. The first violation is returned as a
+// *ValidationError.
+func (chain *BlockChain) Verify() error {
+	var blocks []*Block
+
+	hash := chain.LastHash
+	for len(hash) != 0 {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return &ValidationError{BlockHash: hash, Height: -1, Err: fmt.Errorf("%w: %v", ErrUnknownParent, err)}
+		}
+		if !bytes.Equal(block.Hash, hash) {
+			return &ValidationError{BlockHash: hash, Height: block.Height, Err: ErrBadLink}
+		}
+
+		blocks = append(blocks, &block)
+		hash = block.PrevHash
+	}
+
+	coins := &memoryCoins{make(map[string]coin), make(map[string]bool)}
+	now := time.Now()
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		if err := chain.CheckBlockHeader(block, now); err != nil {
+			return &ValidationError{BlockHash: block.Hash, Height: block.Height, Err: err}
+		}
+
+		medianTime, err := chain.parentMedianTime(block)
+		if err != nil {
+			return &ValidationError{BlockHash: block.Hash, Height: block.Height, Err: err}
+		}
+
+		if err := verifyBlockTransactions(block, coins, chain.params(), medianTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// coinView is the set of outputs the transactions of a block can spend.
+type coinView interface {
+	// spend marks the output referenced by the input as spent and returns the coin of the transaction that created
+	// it. The error wraps ErrMissingInput or ErrSpentInput.
+	spend(in TxInput) (coin, error)
+	// add makes the outputs of the transaction spendable, the transaction being in the block at height whose parent
+	// has the median time past medianTime.
+	add(tx *Transaction, height int, medianTime int64)
+	// unspent reports whether the transaction with the ID still has outputs left to spend, which a new transaction
+	// with the same ID would overwrite.
+	unspent(txID []byte) (bool, error)
+}
+
+// memoryCoins is the coin view of a chain replayed in memory from genesis.
+type memoryCoins struct {
+	coins map[string]coin
+	spent map[string]bool
+}
+
+func (c *memoryCoins) spend(in TxInput) (coin, error) {
+	prev, ok := c.coins[hex.EncodeToString(in.ID)]
+	if !ok || in.Out < 0 || in.Out >= len(prev.tx.Outputs) {
+		return coin{}, fmt.Errorf("%w: %x:%d", ErrMissingInput, in.ID, in.Out)
+	}
+
+	op := outpoint(in.ID, in.Out)
+	if c.spent[op] {
+		return coin{}, fmt.Errorf("%w: %s", ErrSpentInput, op)
+	}
+	c.spent[op] = true
+
+	return prev, nil
+}
+
+func (c *memoryCoins) add(tx *Transaction, height int, medianTime int64) {
+	c.coins[hex.EncodeToString(tx.ID)] = coin{tx, height, medianTime}
+}
+
+func (c *memoryCoins) unspent(txID []byte) (bool, error) {
+	prev, ok := c.coins[hex.EncodeToString(txID)]
+	if !ok {
+		return false, nil
+	}
+
+	for outIdx := range prev.tx.Outputs {
+		if !c.spent[outpoint(txID, outIdx)] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// blockError reports a block, and the transaction tx when it is not nil, breaking a rule.
+func blockError(block *Block, tx *Transaction, err error) error {
+	var txID []byte
+	if tx != nil {
+		txID = tx.ID
+	}
+
+	return &ValidationError{BlockHash: block.Hash, Height: block.Height, TxID: txID, Err: err}
+}
+
+// CheckBlockSanity runs the checks that need nothing but the block itself: the block starts with its only
+// coinbase, every transaction ID is the hash of the unsigned transaction and appears once, and the output values
+// and their sums are in range. ImportBlock runs it before storing a block of a side branch, so a block stored under
+// a hash holds the transactions that hash commits to.
+func CheckBlockSanity(block *Block) error {
+	fail := func(tx *Transaction, err error) error {
+		return blockError(block, tx, err)
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return fail(nil, ErrNoCoinbase)
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return fail(tx, ErrMultipleCoinbase)
+		}
+		if !bytes.Equal(tx.ID, tx.UnsignedHash()) {
+			return fail(tx, ErrBadTxID)
+		}
+		if seen[string(tx.ID)] {
+			return fail(tx, ErrDuplicateTx)
+		}
+		seen[string(tx.ID)] = true
+
+		outputs := 0
+		for _, out := range tx.Outputs {
+			// a coinbase mints nothing once the supply is exhausted and the block pays no fees, a data output
+			// carries no value.
+			if out.Value < 0 || (out.Value == 0 && !tx.IsCoinbase() && !out.IsUnspendable()) {
+				return fail(tx, ErrBadValue)
+			}
+			var err error
+			if outputs, err = addMoney(outputs, out.Value); err != nil {
+				return fail(tx, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifyBlockTransactions checks the transactions of a block against the coin view and applies them to it. The
+// block must pass CheckBlockSanity and no transaction may reuse the ID of a transaction with unspent outputs, whose
+// outputs it would overwrite. The inputs must be worth at least the outputs, with sums staying within MaxMoney. The
+// coinbase may claim the subsidy params allow at the height of the block plus the fees. The locks of the
+// transactions are checked against the height of the block and medianTime, the median time past of its parent.
+func verifyBlockTransactions(block *Block, coins coinView, params *Params, medianTime int64) error {
+	fail := func(tx *Transaction, err error) error {
+		return blockError(block, tx, err)
+	}
+
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+	coinbase := block.Transactions[0]
+
+	fees := 0
+	for _, tx := range block.Transactions {
+		unspent, err := coins.unspent(tx.ID)
+		if err != nil {
+			return fail(tx, err)
+		}
+		if unspent {
+			return fail(tx, ErrTxIDInUse)
+		}
+
+		if !tx.IsCoinbase() {
+			outputs := 0
+			for _, out := range tx.Outputs {
+				outputs += out.Value
+			}
+
+			inputs := 0
+			prevTXs := make(map[string]*Transaction)
+			var spent []coin
+
+			for _, in := range tx.Inputs {
+				prev, err := coins.spend(in)
+				if err != nil {
+					return fail(tx, err)
+				}
+
+				if inputs, err = addMoney(inputs, prev.tx.Outputs[in.Out].Value); err != nil {
+					return fail(tx, err)
+				}
+				prevTXs[hex.EncodeToString(in.ID)] = prev.tx
+				spent = append(spent, prev)
+			}
+
+			if err := checkLocks(tx, spent, block.Height, medianTime); err != nil {
+				return fail(tx, err)
+			}
+
+			if err := tx.VerifyScripts(prevTXs); err != nil {
+				return fail(tx, fmt.Errorf("%w: %v", ErrBadSignature, err))
+			}
+
+			if outputs > inputs {
+				return fail(tx, fmt.Errorf("%w: %d out of %d", ErrValueImbalance, outputs, inputs))
+			}
+			if fees, err = addMoney(fees, inputs-outputs); err != nil {
+				return fail(tx, err)
+			}
+		} else if !tx.IsFinal(block.Height, medianTime) {
+			return fail(tx, ErrTxNotFinal)
+		}
+
+		coins.add(tx, block.Height, medianTime)
+	}
+
+	// the outputs of the coinbase were bounded by CheckBlockSanity.
+	claimed := 0
+	for _, out := range coinbase.Outputs {
+		claimed += out.Value
+	}
+	if allowed := params.GetBlockSubsidy(block.Height) + fees; claimed > allowed {
+		return fail(coinbase, fmt.Errorf("%w: %d out of %d", ErrBadCoinbaseValue, claimed, allowed))
+	}
+
+	return nil
+}
+
+// addMoney adds value to total, failing with ErrValueOutOfRange when the value or the sum is negative or above
+// MaxMoney. total must be a sum addMoney returned.
+func addMoney(total, value int) (int, error) {
+	if value < 0 || value > MaxMoney || total > MaxMoney-value {
+		return 0, fmt.Errorf("%w: %d plus %d", ErrValueOutOfRange, total, value)
+	}
+
+	return total + value, nil
+}
@@ -0,0 +1,303 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func TestCheckBlockHeaderTimestamp(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	// out of order timestamps, each after the median of the blocks before it. The median of the last 11 is not the
+	// median of the whole chain.
+	timestamps := []int64{genesis.Timestamp}
+	for _, offset := range []int64{10, 20, 30, 40, 50, 60, 35, 70, 45, 80, 55, 90, 65} {
+		block := mineAt(t, chain, tip(t, chain), genesis.Timestamp+offset, w)
+		if _, err := chain.ImportBlock(block); err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	parent := tip(t, chain)
+
+	last := append([]int64{}, timestamps[len(timestamps)-medianTimeBlocks:]...)
+	sort.Slice(last, func(i, j int) bool { return last[i] < last[j] })
+	median := last[medianTimeBlocks/2]
+	if got, err := chain.MedianTimePast(parent.Hash); err != nil || got != median {
+		t.Fatalf("MedianTimePast = %d, %v, want %d", got, err, median)
+	}
+
+	now := time.Unix(median+1000, 0)
+	future := now.Add(MaxFutureBlockTime).Unix()
+	tests := []struct {
+		name      string
+		timestamp int64
+		want      error
+	}{
+		{"at the median time past", median, ErrTimeTooOld},
+		{"before the median time past", median - 1, ErrTimeTooOld},
+		{"just after the median time past", median + 1, nil},
+		{"at the future limit", future, nil},
+		{"past the future limit", future + 1, ErrTimeTooNew},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := mineAt(t, chain, parent, test.timestamp, w)
+			err := chain.CheckBlockHeader(block, now)
+			if !errors.Is(err, test.want) {
+				t.Errorf("CheckBlockHeader = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewTransactionLockedWallet(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+
+	// the wallet of a locked wallet file only has its public key.
+	locked := &wallet.Wallet{PublicKey: w.PublicKey}
+	_, err := NewTransaction(locked, string(wallet.MakeWallet().Address()), 5, &UTXOSet{chain}, TxOptions{})
+	if !errors.Is(err, wallet.ErrWalletLocked) {
+		t.Fatalf("NewTransaction = %v, want %v", err, wallet.ErrWalletLocked)
+	}
+}
+
+func TestConnectBlockRejectsTxID(t *testing.T) {
+	w := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	tests := []struct {
+		name string
+		txs  func() []*Transaction
+		err  error
+	}{
+		{
+			name: "ID is not the hash",
+			txs: func() []*Transaction {
+				tx := pay(t, w, genesis.Transactions[0], 0, to, 20)
+				tx.ID = append([]byte{}, genesis.Transactions[0].ID...)
+
+				return []*Transaction{tx}
+			},
+			err: ErrBadTxID,
+		},
+		{
+			name: "outputs changed after signing",
+			txs: func() []*Transaction {
+				tx := pay(t, w, genesis.Transactions[0], 0, to, 20)
+				tx.Outputs[0].Value = 10
+
+				return []*Transaction{tx}
+			},
+			err: ErrBadTxID,
+		},
+		{
+			name: "same transaction twice",
+			txs: func() []*Transaction {
+				tx := pay(t, w, genesis.Transactions[0], 0, to, 20)
+
+				return []*Transaction{tx, tx}
+			},
+			err: ErrDuplicateTx,
+		},
+		{
+			name: "ID with unspent outputs",
+			txs: func() []*Transaction {
+				return []*Transaction{CoinBaseTx(string(w.Address()), genesisData, 20)}
+			},
+			err: ErrTxIDInUse,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txs := test.txs()
+			var block *Block
+			if txs[0].IsCoinbase() {
+				// the coinbase of the block itself reuses the ID of the genesis coinbase.
+				header, err := chain.NextHeader()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if block, err = CreateBlock(header, txs); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				block = mineOn(t, chain, genesis, w, txs...)
+			}
+
+			err := chain.ConnectBlock(block)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ConnectBlock = %v, want %v", err, test.err)
+			}
+			if string(chain.LastHash) != string(genesis.Hash) {
+				t.Fatal("the tip moved")
+			}
+		})
+	}
+}
+
+func TestSigningKeepsTxID(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+
+	tx := pay(t, w, tip(t, chain).Transactions[0], 0, wallet.MakeWallet(), 20)
+	if string(tx.ID) != string(tx.UnsignedHash()) {
+		t.Fatalf("ID %x, unsigned hash %x", tx.ID, tx.UnsignedHash())
+	}
+	if string(tx.ID) == string(tx.Hash()) {
+		t.Fatal("the hash of the signed transaction does not cover its signature")
+	}
+
+	coinbase := CoinBaseTx(string(w.Address()), "", 20)
+	coinbase.SetExtraNonce(7)
+	if string(coinbase.ID) != string(coinbase.UnsignedHash()) {
+		t.Fatal("the extra nonce is not part of the coinbase ID")
+	}
+}
+
+func TestWitnessRootCommitsToSignatures(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	tx := pay(t, w, genesis.Transactions[0], 0, wallet.MakeWallet(), 20)
+	block := mineOn(t, chain, genesis, w, tx)
+
+	// signing again gives another valid signature under the same ID.
+	malleated := *tx
+	malleated.Inputs = append([]TxInput{}, tx.Inputs...)
+	malleated.Inputs[0].Signature = nil
+	prevTXs := map[string]*Transaction{fmt.Sprintf("%x", genesis.Transactions[0].ID): genesis.Transactions[0]}
+	if err := malleated.Sign(&w.PrivateKey, prevTXs); err != nil {
+		t.Fatal(err)
+	}
+	if string(malleated.ID) != string(tx.ID) || string(malleated.Inputs[0].Signature) == string(tx.Inputs[0].Signature) {
+		t.Fatal("signing again did not malleate the signature alone")
+	}
+
+	forged := *block
+	forged.Transactions = []*Transaction{block.Transactions[0], &malleated}
+	if string(forged.HashTransactions()) != string(block.MerkleRoot) {
+		t.Fatal("the merkle root covers the signatures")
+	}
+	if string(forged.HashWitnesses()) == string(block.WitnessRoot) {
+		t.Fatal("the witness root does not cover the signatures")
+	}
+
+	if _, err := chain.ImportBlock(&forged); !errors.Is(err, ErrBadWitnessRoot) {
+		t.Fatalf("ImportBlock(malleated) = %v, want %v", err, ErrBadWitnessRoot)
+	}
+	if chain.HasBlock(block.Hash) {
+		t.Fatal("the malleated block was stored")
+	}
+
+	importBlocks(t, chain, block)
+	if string(chain.LastHash) != string(block.Hash) {
+		t.Fatal("the original block was not connected")
+	}
+}
+
+func TestMempoolRejectsTxID(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	mempool := NewMempool(&UTXOSet{chain}, DefaultMempoolOptions)
+
+	tx := pay(t, w, tip(t, chain).Transactions[0], 0, wallet.MakeWallet(), 20)
+	tx.Outputs[0].Value = 19
+
+	if err := mempool.Add(tx); !errors.Is(err, ErrTxInvalid) {
+		t.Fatalf("Add = %v, want %v", err, ErrTxInvalid)
+	}
+}
+
+func TestAddMoney(t *testing.T) {
+	tests := []struct {
+		total, value int
+		want         int
+		ok           bool
+	}{
+		{0, 0, 0, true},
+		{1, 2, 3, true},
+		{0, MaxMoney, MaxMoney, true},
+		{MaxMoney - 1, 1, MaxMoney, true},
+		{MaxMoney, 1, 0, false},
+		{0, MaxMoney + 1, 0, false},
+		{0, -1, 0, false},
+		{1, math.MaxInt, 0, false},
+		{MaxMoney, math.MaxInt, 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := addMoney(test.total, test.value)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("addMoney(%d, %d) = %d, %v, want %d", test.total, test.value, got, err, test.want)
+		}
+		if !test.ok && !errors.Is(err, ErrValueOutOfRange) {
+			t.Errorf("addMoney(%d, %d) = %d, %v, want %v", test.total, test.value, got, err, ErrValueOutOfRange)
+		}
+	}
+}
+
+func TestRejectsValuesOutOfRange(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newTestChain(t, w, Options{})
+	genesis := tip(t, chain)
+
+	tests := []struct {
+		name   string
+		values []int
+	}{
+		{"output above the maximum", []int{MaxMoney + 1}},
+		{"outputs summing above the maximum", []int{MaxMoney, 1}},
+		{"outputs overflowing", []int{math.MaxInt/2 + 1, math.MaxInt/2 + 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := pay(t, w, genesis.Transactions[0], 0, w, 20)
+			tx.Outputs = nil
+			for _, value := range test.values {
+				tx.Outputs = append(tx.Outputs, *NewTxOutput(value, string(w.Address())))
+			}
+			tx.SetID()
+
+			err := chain.ConnectBlock(mineOn(t, chain, genesis, w, tx))
+			if !errors.Is(err, ErrValueOutOfRange) {
+				t.Errorf("ConnectBlock = %v, want %v", err, ErrValueOutOfRange)
+			}
+
+			mempool := NewMempool(&UTXOSet{chain}, DefaultMempoolOptions)
+			if err := mempool.Add(tx); !errors.Is(err, ErrTxInvalid) {
+				t.Errorf("Add = %v, want %v", err, ErrTxInvalid)
+			}
+		})
+	}
+}
+
+func TestSpendWithShortCoordinateKey(t *testing.T) {
+	// about one key in 128 has a coordinate with a leading zero byte.
+	var w *wallet.Wallet
+	for w == nil {
+		candidate := wallet.MakeWallet()
+		pub := candidate.PrivateKey.PublicKey
+		if len(pub.X.Bytes()) < coordinateSize || len(pub.Y.Bytes()) < coordinateSize {
+			w = candidate
+		}
+	}
+
+	chain := newTestChain(t, w, Options{})
+	addBlock(t, chain, w, pay(t, w, tip(t, chain).Transactions[0], 0, wallet.MakeWallet(), 20))
+}
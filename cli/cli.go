@@ -1,28 +1,66 @@
 package cli
 
 import (
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tensor-programming/golang-blockchain/blockchain"
+	"github.com/tensor-programming/golang-blockchain/network"
+	"github.com/tensor-programming/golang-blockchain/rpc"
 	"github.com/tensor-programming/golang-blockchain/wallet"
 )
 
-type CommandLine struct{}
+// CommandLine runs the commands on the chain and wallets found in DataDir for Network, both set by the -datadir and
+// -network flags every command accepts.
+type CommandLine struct {
+	DataDir string
+	Network string
+}
+
+func (cli *CommandLine) chainOptions() blockchain.Options {
+	return blockchain.Options{DataDir: cli.DataDir, Network: cli.Network}
+}
+
+func (cli *CommandLine) walletOptions() wallet.Options {
+	return wallet.Options{DataDir: cli.DataDir, Network: cli.Network}
+}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
-	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
-	fmt.Println(" createwallet - Creates a new Wallet")
-	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+	fmt.Println(" createblockchain -address ADDRESS -txindex -addrindex creates a blockchain and sends genesis reward to address, -txindex keeps a transaction index, -addrindex an address index")
+	fmt.Println(" history -address ADDRESS -skip N -count N - Prints the outputs the address received and spent with its balance, from the oldest, leaving out N entries and printing at most N, needs the address index")
+	fmt.Println(" printchain -from FROM -to TO - Prints the blocks in the chain from the tip down, or the blocks from height FROM up to height TO in order when either is given")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT -fee FEE -feerate RATE -coinselect STRATEGY -data DATA -locktime LOCKTIME -sequence SEQUENCE -lockoutput LOCK -out FILE -node NODE - Send amount of coins, leaving FEE or RATE per 1000 bytes to the miner, STRATEGY is one of largest, smallest, bnb or random, -data adds an unspendable output carrying DATA, LOCKTIME is the last height, or unix time from 500000000 on, the transaction cannot be mined at, SEQUENCE the relative lock of the inputs, LOCK the height or unix time the output paying TO cannot be spent until, -out writes the transaction to FILE for sendtx, -node hands the transaction to a running node instead of mining it")
+	fmt.Println(" createwallet -mnemonic -words N -passphrase PASSPHRASE - Creates a new Wallet, -mnemonic first creates the seed of the wallet file from a new mnemonic of N words, the following wallets being derived from it")
+	fmt.Println(" restorewallet -mnemonic MNEMONIC -passphrase PASSPHRASE -gap N - Restores the seed of a mnemonic and the wallets derived from it that hold coins, stopping after N unused ones")
+	fmt.Println(" encryptwallet -passphrase PASSPHRASE - Encrypts the wallet file, createwallet, restorewallet and send then need -walletpassphrase PASSPHRASE")
+	fmt.Println(" walletpassphrase -passphrase PASSPHRASE -timeout SECONDS -rpc HOST:PORT - Unlocks the wallet file of a running RPC server for a while")
+	fmt.Println(" walletlock -rpc HOST:PORT - Locks the wallet file of a running RPC server")
+	fmt.Println(" createmultisig -m M -keys KEYS - Creates the address of the M of N multisig of KEYS, a comma separated list of addresses of our wallet file or hex public keys, and adds it to the wallet file")
+	fmt.Println(" spendmultisig -from MULTISIG -to TO -amount AMOUNT -fee FEE -feerate RATE -coinselect STRATEGY -out FILE - Writes to FILE an unsigned transaction sending amount of coins from a multisig address")
+	fmt.Println(" signtx -in FILE -address ADDRESS - Adds the signature of the wallet of ADDRESS to the transaction in FILE")
+	fmt.Println(" sendtx -in FILE -miner ADDRESS -node NODE - Sends the signed transaction in FILE, mining it with the reward to ADDRESS, -node hands it to a running node instead")
+	fmt.Println(" listaddresses -pubkeys - Lists the addresses in our wallet file, -pubkeys with their public keys")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
+	fmt.Println(" reindextx - Rebuilds the transaction index, creating it when the chain has none")
+	fmt.Println(" reindexaddr - Rebuilds the address index, creating it when the chain has none")
+	fmt.Println(" supply - Prints the coins in circulation, summed over the UTXO set, and the subsidy schedule")
+	fmt.Println(" merkleproof -block BLOCK -tx TXID - Prints the merkle proof that a transaction is in a block")
+	fmt.Println(" verifychain - Replays the whole chain from genesis and reports the first invalid block")
+	fmt.Println(" startnode -port PORT -miner ADDRESS -peers PEERS -workers N - Start a node, -miner enables mining with N goroutines, -peers is a comma separated list of nodes")
+	fmt.Println(" startrpc -port PORT -user USER -password PASSWORD -node NODE - Start a JSON-RPC server, -user and -password are required, -node hands the transactions to a running node instead of mining them")
+	fmt.Println("Every command accepts -datadir DIR, the data directory, and -network NAME, one of mainnet, testnet or regtest")
 }
 
 func (cli *CommandLine) validateArgs() {
@@ -33,71 +71,547 @@ func (cli *CommandLine) validateArgs() {
 }
 
 func (cli *CommandLine) reindexUTXO() {
-	chain := blockchain.ContinueBlockChain()
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
 	defer chain.Database.Close()
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	if err := UTXOSet.Reindex(); err != nil {
+		log.Panic(err)
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CommandLine) listAddresses() {
-	wallets, err := wallet.CreateWallets()
+func (cli *CommandLine) reindexTx() {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	count, err := chain.ReindexTxs()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! There are %d transactions in the transaction index.\n", count)
+}
+
+func (cli *CommandLine) reindexAddr() {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	count, err := chain.ReindexAddresses()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! There are %d entries in the address index.\n", count)
+}
+
+func (cli *CommandLine) history(address string, skip, count int) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	history, total, err := chain.AddressHistory(blockchain.PubKeyHash([]byte(address)), skip, count)
+	if errors.Is(err, blockchain.ErrNoAddrIndex) {
+		log.Panic("The chain has no address index, build it with reindexaddr")
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("History of %s: %d entries\n", address, total)
+	for _, entry := range history {
+		change := "received"
+		if entry.Spent {
+			change = "spent"
+		}
+		fmt.Printf("Height %d tx %x:%d %s %d balance %d\n", entry.Height, entry.TxID, entry.Index, change,
+			entry.Amount, entry.Balance)
+	}
+}
+
+func (cli *CommandLine) supply() {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	circulating, err := UTXOSet.TotalValue()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Circulating supply: %d\n", circulating)
+	fmt.Printf("Max supply: %d\n", chain.Params.MaxSupply)
+	fmt.Printf("Next block subsidy: %d\n", chain.Params.GetBlockSubsidy(height+1))
+}
+
+func (cli *CommandLine) listAddresses(pubKeys bool) {
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
 	if err != nil {
 		log.Fatal(err)
 	}
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
+		if pubKeys {
+			fmt.Printf("%s %x\n", address, wallets.Wallets[address].PublicKey)
+			continue
+		}
 		fmt.Println(address)
 	}
+
+	for address, ms := range wallets.Multisigs {
+		fmt.Printf("%s %d of %d multisig\n", address, ms.M, len(ms.PubKeys))
+	}
+}
+
+func (cli *CommandLine) createMultisig(m int, keys string) {
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var pubKeys [][]byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if w, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, w.PublicKey)
+			continue
+		}
+
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panicf("%s is neither an address of the wallet file nor a public key", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	ms, err := wallet.NewMultisig(m, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+	address := wallets.AddMultisig(ms)
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Multisig address: %s\n", address)
+	for _, pubKey := range ms.PubKeys {
+		fmt.Printf("Key: %x\n", pubKey)
+	}
+}
+
+func (cli *CommandLine) spendMultisig(from, to string, amount int, txOpts blockchain.TxOptions, out string) {
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address is not Valid")
+	}
+
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	ms, ok := wallets.Multisigs[from]
+	if !ok {
+		log.Panicf("No multisig for %s, add it with createmultisig", from)
+	}
+
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	utxo := blockchain.UTXOSet{Blockchain: chain}
+	tx, err := blockchain.NewMultisigTransaction(ms, to, amount, &utxo, txOpts)
+	if err != nil {
+		log.Panic(err)
+	}
+	writeTx(out, tx)
+
+	fmt.Printf("Unsigned transaction %x written to %s, it needs %d signatures\n", tx.ID, out, ms.M)
 }
 
-func (cli *CommandLine) createWallet() {
-	wallets, err := wallet.CreateWallets()
+func (cli *CommandLine) signTx(in, address, walletPassphrase string) {
+	tx := readTx(in)
+
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, walletPassphrase)
+	w, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panicf("No wallet for %s", address)
+	}
+
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	if err := chain.SignTx(tx, &w.PrivateKey); err != nil {
+		log.Panic(err)
+	}
+	writeTx(in, tx)
+
+	for i, signed := range tx.SignatureCount() {
+		fmt.Printf("Multisig input %d: %d signatures\n", i, signed)
+	}
+}
+
+func (cli *CommandLine) sendTx(in, minerAddress, nodeAddress string) {
+	tx := readTx(in)
+
+	if nodeAddress != "" {
+		if err := network.SendTxTo(nodeAddress, tx); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Transaction %x sent to %s\n", tx.ID, nodeAddress)
+		return
+	}
+
+	if !wallet.ValidateAddress(minerAddress) {
+		log.Panic("Wrong miner address!")
+	}
+
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	utxo := blockchain.UTXOSet{Blockchain: chain}
+	mempool := blockchain.NewMempool(&utxo, blockchain.DefaultMempoolOptions)
+	if err := mempool.Add(tx); err != nil {
+		log.Panic(err)
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if _, err := chain.AddBlock(mempool.BlockTemplate(minerAddress, height+1, 0)); err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Success!")
+}
+
+// writeTx stores a transaction being signed in a file, as hex.
+func writeTx(file string, tx *blockchain.Transaction) {
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(tx.Serialize())+"\n"), 0644); err != nil {
+		log.Panic(err)
+	}
+}
+
+func readTx(file string) *blockchain.Transaction {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := blockchain.DeserializeTransaction(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return &tx
+}
+
+// unlockWallets unlocks an encrypted wallet file for the duration of the command.
+func unlockWallets(wallets *wallet.Wallets, walletPassphrase string) {
+	if !wallets.Locked() {
+		return
+	}
+
+	if walletPassphrase == "" {
+		log.Panic("The wallet file is encrypted, unlock it with -walletpassphrase")
+	}
+	if err := wallets.Unlock(walletPassphrase); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (cli *CommandLine) createWallet(mnemonic bool, words int, passphrase, walletPassphrase string) {
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
 	if err != nil {
 		log.Fatal(err)
 	}
-	address := wallets.AddWallet()
-	wallets.SaveFile()
+	unlockWallets(wallets, walletPassphrase)
+
+	if mnemonic {
+		if wallets.Seed != nil {
+			log.Panic(wallet.ErrSeedExists)
+		}
+
+		phrase, err := wallet.GenerateMnemonic(words * 32 / 3)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := wallets.SetSeed(wallet.NewSeed(phrase, passphrase)); err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Mnemonic, write it down to restore the wallets: %s\n", phrase)
+	}
+
+	address, err := wallets.AddWallet()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Printf("New address is: %s\n", address)
 }
 
-func (cli *CommandLine) printChain() {
-	chain := blockchain.ContinueBlockChain()
+func (cli *CommandLine) restoreWallet(mnemonic, passphrase string, gapLimit int, walletPassphrase string) {
+	if _, err := wallet.MnemonicToEntropy(mnemonic); err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, walletPassphrase)
+	if err := wallets.SetSeed(wallet.NewSeed(mnemonic, passphrase)); err != nil {
+		log.Panic(err)
+	}
+
+	var found []string
+	if blockchain.DBexists(cli.chainOptions()) {
+		chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+		if err != nil {
+			log.Panic(err)
+		}
+		defer chain.Database.Close()
+
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+		found, err = wallets.Discover(gapLimit, func(address string) (bool, error) {
+			outputs, err := UTXOSet.FindUTXO(blockchain.PubKeyHash([]byte(address)))
+			return len(outputs) > 0, err
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if len(found) == 0 {
+		address, err := wallets.AddWallet()
+		if err != nil {
+			log.Panic(err)
+		}
+		found = append(found, address)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+
+	for _, address := range found {
+		fmt.Printf("Restored address: %s\n", address)
+	}
+}
+
+func (cli *CommandLine) encryptWallet(passphrase string) {
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := wallets.Encrypt(passphrase); err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Wallet file encrypted, commands spending coins now need -walletpassphrase")
+}
+
+func (cli *CommandLine) walletPassphrase(client *rpc.Client, passphrase string, timeout int) {
+	if err := client.WalletPassphrase(passphrase, timeout); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wallet unlocked for %d seconds\n", timeout)
+}
+
+func (cli *CommandLine) walletLock(client *rpc.Client) {
+	if err := client.WalletLock(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Wallet locked")
+}
+
+func (cli *CommandLine) printChain(from, to int) {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
 	defer chain.Database.Close()
-	iter := chain.Iterator()
 
-	for {
-		block := iter.Next()
+	if from < 0 && to < 0 {
+		iter := chain.Iterator()
+
+		for {
+			block, err := iter.Next()
+			if err != nil {
+				log.Panic(err)
+			}
+			printBlock(block)
 
-		fmt.Printf("Hash: %x\n", block.Hash)
-		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+			if len(block.PrevHash) == 0 {
+				break
+			}
 		}
-		fmt.Println()
 
-		if len(block.PrevHash) == 0 {
+		return
+	}
+
+	if from < 0 {
+		from = 0
+	}
+	iter, err := chain.ForwardIterator(from)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			log.Panic(err)
+		}
+		if block == nil || (to >= 0 && block.Height > to) {
 			break
 		}
+		printBlock(block)
 	}
 }
 
-func (cli *CommandLine) createBlockChain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+func printBlock(block *blockchain.Block) {
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Prev. hash: %x\n", block.PrevHash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Version: %d\n", block.Version)
+	fmt.Printf("Timestamp: %s\n", time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	if block.Version >= 2 {
+		fmt.Printf("Witness root: %x\n", block.WitnessRoot)
+	}
+	pow := blockchain.NewProof(block)
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
 	}
-	chain := blockchain.InitBlockChain(address)
-	chain.Database.Close()
+	fmt.Println()
+}
 
-	utxo := blockchain.UTXOSet{Blockchain: chain}
-	utxo.Reindex()
+func (cli *CommandLine) merkleProof(blockHash, txID string) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	ID, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	proof, err := block.MerkleProof(ID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	merkleRoot := block.MerkleRoot
+
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Merkle root: %x\n", merkleRoot)
+	fmt.Printf("Transaction: %x\n", proof.TxID)
+	fmt.Printf("Index: %d\n", proof.Index)
+	for i, hash := range proof.Hashes {
+		fmt.Printf("Hash %d: %x\n", i, hash)
+	}
+	fmt.Printf("Valid: %s\n", strconv.FormatBool(proof.Verify(merkleRoot)))
+}
+
+func (cli *CommandLine) verifyChain() {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	if err := chain.Verify(); err != nil {
+		fmt.Printf("Chain is invalid: %v\n", err)
+		return
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
 
+	fmt.Printf("Chain is valid up to height %d\n", height)
+}
+
+func (cli *CommandLine) createBlockChain(address string, txIndex, addrIndex bool) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Address is not Valid")
+	}
+	opts := cli.chainOptions()
+	opts.TxIndex = txIndex
+	opts.AddrIndex = addrIndex
+	chain, err := blockchain.InitBlockChain(address, opts)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+	fmt.Println("Genesis created")
 	fmt.Println("Finished!")
 }
 
@@ -105,13 +619,19 @@ func (cli *CommandLine) getBalance(address string) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain.ContinueBlockChain()
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
 	defer chain.Database.Close()
 
 	utxo := blockchain.UTXOSet{Blockchain: chain}
 
 	balance := 0
-	UTXOs := utxo.FindUTXO(blockchain.PubKeyHash([]byte(address)))
+	UTXOs, err := utxo.FindUTXO(blockchain.PubKeyHash([]byte(address)))
+	if err != nil {
+		log.Panic(err)
+	}
 
 	for _, out := range UTXOs {
 		balance += out.Value
@@ -120,24 +640,140 @@ func (cli *CommandLine) getBalance(address string) {
 	fmt.Printf("Balance of %s: %d\n", address, balance)
 }
 
-func (cli *CommandLine) send(from, to string, amount int) {
+func (cli *CommandLine) send(from, to string, amount int, txOpts blockchain.TxOptions, out, nodeAddress,
+	walletPassphrase string) {
 	if !wallet.ValidateAddress(to) {
 		log.Panic("Address is not Valid")
 	}
 	if !wallet.ValidateAddress(from) {
 		log.Panic("Address is not Valid")
 	}
-	chain := blockchain.ContinueBlockChain()
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
 	defer chain.Database.Close()
 
 	utxo := blockchain.UTXOSet{Blockchain: chain}
 
-	tx := blockchain.NewTransaction(from, to, amount, &utxo)
-	cbTx := blockchain.CoinBaseTx(from, "")
-	chain.AddBlock([]*blockchain.Transaction{tx, cbTx})
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	unlockWallets(wallets, walletPassphrase)
+	w, ok := wallets.Wallets[from]
+	if !ok {
+		log.Panicf("No wallet for %s", from)
+	}
+
+	tx, err := blockchain.NewTransaction(w, to, amount, &utxo, txOpts)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if out != "" {
+		writeTx(out, tx)
+		fmt.Printf("Transaction %x written to %s\n", tx.ID, out)
+		return
+	}
+
+	if nodeAddress != "" {
+		if err := network.SendTxTo(nodeAddress, tx); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Transaction %x sent to %s\n", tx.ID, nodeAddress)
+		return
+	}
+
+	mempool := blockchain.NewMempool(&utxo, blockchain.DefaultMempoolOptions)
+	if err := mempool.Add(tx); err != nil {
+		log.Panic(err)
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if _, err := chain.AddBlock(mempool.BlockTemplate(from, height+1, 0)); err != nil {
+		log.Panic(err)
+	}
 	fmt.Println("Success!")
 }
 
+func (cli *CommandLine) startNode(port, minerAddress, peers string, workers int) {
+	if minerAddress != "" {
+		if !wallet.ValidateAddress(minerAddress) {
+			log.Panic("Wrong miner address!")
+		}
+		fmt.Printf("Mining is on. Address to receive rewards: %s\n", minerAddress)
+	}
+
+	var seeds []string
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			seeds = append(seeds, peer)
+		}
+	}
+
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	node := network.NewNode(fmt.Sprintf("localhost:%s", port), minerAddress, chain, seeds)
+	node.Miner = &blockchain.Miner{
+		Workers:        workers,
+		ReportInterval: 10 * time.Second,
+		OnHashRate: func(hashesPerSecond float64) {
+			log.Printf("Mining at %.0f hashes per second", hashesPerSecond)
+		},
+	}
+	if err := node.Start(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Starting node %s\n", node.Address)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+
+	if err := node.Stop(); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (cli *CommandLine) startRPC(port, user, password, nodeAddress string) {
+	chain, err := blockchain.ContinueBlockChain(cli.chainOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.CreateWallets(cli.walletOptions())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	server := rpc.NewServer(fmt.Sprintf("localhost:%s", port), chain, wallets)
+	server.User = user
+	server.Password = password
+	server.NodeAddress = nodeAddress
+	if err := server.Start(); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Starting RPC server %s\n", server.Address)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+
+	if err := server.Stop(); err != nil {
+		log.Panic(err)
+	}
+}
+
 func (cli *CommandLine) Run() {
 	cli.validateArgs()
 
@@ -146,14 +782,102 @@ func (cli *CommandLine) Run() {
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	reindexAddrCmd := flag.NewFlagSet("reindexaddr", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	merkleProofCmd := flag.NewFlagSet("merkleproof", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	startRPCCmd := flag.NewFlagSet("startrpc", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	walletLockCmd := flag.NewFlagSet("walletlock", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	spendMultisigCmd := flag.NewFlagSet("spendmultisig", flag.ExitOnError)
+	signTxCmd := flag.NewFlagSet("signtx", flag.ExitOnError)
+	sendTxCmd := flag.NewFlagSet("sendtx", flag.ExitOnError)
 
+	printChainFrom := printChainCmd.Int("from", -1, "Height of the first block to print")
+	printChainTo := printChainCmd.Int("to", -1, "Height of the last block to print, the tip by default")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", false, "Keep a transaction index")
+	createBlockchainAddrIndex := createBlockchainCmd.Bool("addrindex", false, "Keep an address index")
+	historyAddress := historyCmd.String("address", "", "The address to print the history of")
+	historySkip := historyCmd.Int("skip", 0, "Number of entries to leave out, from the oldest")
+	historyCount := historyCmd.Int("count", 0, "Maximum number of entries to print, all of them when 0")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, "Create the seed of the wallet file from a new mnemonic")
+	createWalletWords := createWalletCmd.Int("words", 12, "Number of words of the mnemonic: 12, 15, 18, 21 or 24")
+	createWalletPassphrase := createWalletCmd.String("passphrase", "", "Optional passphrase protecting the mnemonic")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic of the seed to restore")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase the mnemonic was created with")
+	restoreWalletGap := restoreWalletCmd.Int("gap", 20, "Number of unused addresses in a row that ends the scan")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "Passphrase encrypting the wallet file")
+	walletPassphrasePassphrase := walletPassphraseCmd.String("passphrase", "", "Passphrase of the wallet file")
+	walletPassphraseTimeout := walletPassphraseCmd.Int("timeout", 60, "Seconds before the wallet file is locked again")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee per 1000 bytes of the transaction, used when -fee is not set")
+	sendCoinSelect := sendCmd.String("coinselect", "largest", "Coin selection strategy: largest, smallest, bnb or random")
+	sendData := sendCmd.String("data", "", "Data carried by an unspendable output of the transaction")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Last height, or unix time from 500000000 on, the transaction cannot be mined at")
+	sendSequence := sendCmd.Uint("sequence", 0, "Relative lock of the inputs: blocks, or units of 512 seconds with bit 22 set")
+	sendLockOutput := sendCmd.Int64("lockoutput", 0, "Height, or unix time from 500000000 on, the output paying the destination is locked until")
+	sendOut := sendCmd.String("out", "", "File to write the transaction to instead of sending it")
+	sendNode := sendCmd.String("node", "", "Address of the node to send the transaction to")
+	listAddressesPubKeys := listAddressesCmd.Bool("pubkeys", false, "Print the public key of every address")
+	createMultisigM := createMultisigCmd.Int("m", 0, "Number of signatures needed to spend")
+	createMultisigKeys := createMultisigCmd.String("keys", "", "Comma separated addresses of the wallet file or hex public keys")
+	spendMultisigFrom := spendMultisigCmd.String("from", "", "Source multisig address")
+	spendMultisigTo := spendMultisigCmd.String("to", "", "Destination wallet address")
+	spendMultisigAmount := spendMultisigCmd.Int("amount", 0, "Amount to send")
+	spendMultisigFee := spendMultisigCmd.Int("fee", 0, "Fee left to the miner")
+	spendMultisigFeeRate := spendMultisigCmd.Int("feerate", 0, "Fee per 1000 bytes of the signed transaction, used when -fee is not set")
+	spendMultisigCoinSelect := spendMultisigCmd.String("coinselect", "largest", "Coin selection strategy: largest, smallest, bnb or random")
+	spendMultisigOut := spendMultisigCmd.String("out", "", "File to write the unsigned transaction to")
+	signTxIn := signTxCmd.String("in", "", "File holding the transaction, rewritten with the signature")
+	signTxAddress := signTxCmd.String("address", "", "Address of the wallet signing")
+	sendTxIn := sendTxCmd.String("in", "", "File holding the signed transaction")
+	sendTxMiner := sendTxCmd.String("miner", "", "Address the reward of the block mining the transaction goes to")
+	sendTxNode := sendTxCmd.String("node", "", "Address of the node to send the transaction to")
+	startNodePort := startNodeCmd.String("port", "3000", "Port the node listens on")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodePeers := startNodeCmd.String("peers", "", "Comma separated addresses of the nodes to connect to")
+	startNodeWorkers := startNodeCmd.Int("workers", 0, "Number of mining goroutines, one per CPU when 0")
+	merkleProofBlock := merkleProofCmd.String("block", "", "Hash of the block holding the transaction")
+	merkleProofTx := merkleProofCmd.String("tx", "", "ID of the transaction to prove")
+	startRPCPort := startRPCCmd.String("port", "8332", "Port the RPC server listens on")
+	startRPCUser := startRPCCmd.String("user", "", "User of the HTTP basic authentication, required")
+	startRPCPassword := startRPCCmd.String("password", "", "Password of the HTTP basic authentication, required")
+	startRPCNode := startRPCCmd.String("node", "", "Address of the node to send the transactions to")
+
+	// the wallet file is encrypted with -passphrase by encryptwallet, the other commands unlock it with
+	// -walletpassphrase since their -passphrase is the one of the mnemonic.
+	var walletPassphrase string
+	for _, cmd := range []*flag.FlagSet{createWalletCmd, restoreWalletCmd, sendCmd, signTxCmd} {
+		cmd.StringVar(&walletPassphrase, "walletpassphrase", "", "Passphrase unlocking an encrypted wallet file")
+	}
+
+	var rpcAddress, rpcUser, rpcPassword string
+	for _, cmd := range []*flag.FlagSet{walletPassphraseCmd, walletLockCmd} {
+		cmd.StringVar(&rpcAddress, "rpc", "localhost:8332", "Address of the RPC server")
+		cmd.StringVar(&rpcUser, "rpcuser", "", "User of the RPC server")
+		cmd.StringVar(&rpcPassword, "rpcpassword", "", "Password of the RPC server")
+	}
+
+	for _, cmd := range []*flag.FlagSet{getBalanceCmd, createBlockchainCmd, sendCmd, printChainCmd, createWalletCmd,
+		listAddressesCmd, reindexUTXOCmd, startNodeCmd, merkleProofCmd, verifyChainCmd, startRPCCmd,
+		supplyCmd, restoreWalletCmd, encryptWalletCmd, walletPassphraseCmd, walletLockCmd, createMultisigCmd,
+		spendMultisigCmd, signTxCmd, sendTxCmd, reindexTxCmd, reindexAddrCmd, historyCmd} {
+		cmd.StringVar(&cli.DataDir, "datadir", blockchain.DefaultDataDir, "Data directory")
+		cmd.StringVar(&cli.Network, "network", blockchain.MainNet, "Network: mainnet, testnet or regtest")
+	}
 
 	switch os.Args[1] {
 	case "getbalance":
@@ -166,6 +890,21 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexaddr":
+		err := reindexAddrCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "createblockchain":
 		err := createBlockchainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -181,6 +920,11 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "printchain":
 		err := printChainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -191,6 +935,66 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "merkleproof":
+		err := merkleProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startrpc":
+		err := startRPCCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletpassphrase":
+		err := walletPassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletlock":
+		err := walletLockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spendmultisig":
+		err := spendMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signtx":
+		err := signTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendtx":
+		err := sendTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		runtime.Goexit()
@@ -209,30 +1013,168 @@ func (cli *CommandLine) Run() {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockChain(*createBlockchainAddress)
+		cli.createBlockChain(*createBlockchainAddress, *createBlockchainTxIndex, *createBlockchainAddrIndex)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain()
+		cli.printChain(*printChainFrom, *printChainTo)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		if *createWalletWords < 12 || *createWalletWords > 24 || *createWalletWords%3 != 0 {
+			createWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createWallet(*createWalletMnemonic, *createWalletWords, *createWalletPassphrase, walletPassphrase)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" || *restoreWalletGap <= 0 {
+			restoreWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletPassphrase, *restoreWalletGap, walletPassphrase)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.encryptWallet(*encryptWalletPassphrase)
+	}
+
+	if walletPassphraseCmd.Parsed() {
+		if *walletPassphrasePassphrase == "" || *walletPassphraseTimeout <= 0 {
+			walletPassphraseCmd.Usage()
+			runtime.Goexit()
+		}
+		client := rpc.NewClient("http://"+rpcAddress, rpcUser, rpcPassword)
+		cli.walletPassphrase(client, *walletPassphrasePassphrase, *walletPassphraseTimeout)
+	}
+
+	if walletLockCmd.Parsed() {
+		cli.walletLock(rpc.NewClient("http://"+rpcAddress, rpcUser, rpcPassword))
 	}
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
+		cli.listAddresses(*listAddressesPubKeys)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigM <= 0 || *createMultisigKeys == "" {
+			createMultisigCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createMultisig(*createMultisigM, *createMultisigKeys)
+	}
+
+	if spendMultisigCmd.Parsed() {
+		if *spendMultisigFrom == "" || *spendMultisigTo == "" || *spendMultisigAmount <= 0 || *spendMultisigFee < 0 ||
+			*spendMultisigFeeRate < 0 || *spendMultisigOut == "" {
+			spendMultisigCmd.Usage()
+			runtime.Goexit()
+		}
+
+		selector, err := blockchain.NewCoinSelector(*spendMultisigCoinSelect)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		txOpts := blockchain.TxOptions{Fee: *spendMultisigFee, FeeRate: *spendMultisigFeeRate, CoinSelector: selector}
+		cli.spendMultisig(*spendMultisigFrom, *spendMultisigTo, *spendMultisigAmount, txOpts, *spendMultisigOut)
+	}
+
+	if signTxCmd.Parsed() {
+		if *signTxIn == "" || *signTxAddress == "" {
+			signTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.signTx(*signTxIn, *signTxAddress, walletPassphrase)
+	}
+
+	if sendTxCmd.Parsed() {
+		if *sendTxIn == "" || (*sendTxMiner == "" && *sendTxNode == "") {
+			sendTxCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.sendTx(*sendTxIn, *sendTxMiner, *sendTxNode)
 	}
 
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO()
 	}
 
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx()
+	}
+
+	if reindexAddrCmd.Parsed() {
+		cli.reindexAddr()
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historySkip < 0 || *historyCount < 0 {
+			historyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.history(*historyAddress, *historySkip, *historyCount)
+	}
+
+	if supplyCmd.Parsed() {
+		cli.supply()
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendFeeRate < 0 || *sendLockTime < 0 ||
+			*sendSequence > math.MaxUint32 || *sendLockOutput < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		selector, err := blockchain.NewCoinSelector(*sendCoinSelect)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		txOpts := blockchain.TxOptions{
+			Fee:          *sendFee,
+			FeeRate:      *sendFeeRate,
+			CoinSelector: selector,
+			LockTime:     *sendLockTime,
+			Sequence:     uint32(*sendSequence),
+			LockOutput:   *sendLockOutput,
+		}
+		if *sendData != "" {
+			txOpts.Data = []byte(*sendData)
+		}
+		cli.send(*sendFrom, *sendTo, *sendAmount, txOpts, *sendOut, *sendNode, walletPassphrase)
+	}
+
+	if startNodeCmd.Parsed() {
+		if *startNodePort == "" {
+			startNodeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.startNode(*startNodePort, *startNodeMiner, *startNodePeers, *startNodeWorkers)
+	}
+
+	if merkleProofCmd.Parsed() {
+		if *merkleProofBlock == "" || *merkleProofTx == "" {
+			merkleProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.merkleProof(*merkleProofBlock, *merkleProofTx)
+	}
+
+	if verifyChainCmd.Parsed() {
+		cli.verifyChain()
+	}
+
+	if startRPCCmd.Parsed() {
+		if *startRPCPort == "" || *startRPCUser == "" || *startRPCPassword == "" {
+			startRPCCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.startRPC(*startRPCPort, *startRPCUser, *startRPCPassword, *startRPCNode)
 	}
 }
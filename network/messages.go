@@ -0,0 +1,110 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+)
+
+const (
+	protocol        = "tcp"
+	protocolVersion = 2
+	commandLength   = 12
+)
+
+// Addr shares the addresses of known nodes.
+type Addr struct {
+	AddrList []string
+}
+
+// Block carries a serialized block.
+type Block struct {
+	AddrFrom string
+	Block    []byte
+}
+
+// GetBlocks asks a node for the hashes of its blocks.
+type GetBlocks struct {
+	AddrFrom string
+}
+
+// GetData asks a node for a single block or transaction.
+type GetData struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+// Inv announces blocks or transactions that a node has.
+type Inv struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+// Tx carries a serialized transaction.
+type Tx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// Version is the handshake message, it tells the receiver how long the sender chain is and how much work it holds.
+// BestWork is the cumulative work of the tip, big endian.
+type Version struct {
+	Version    int
+	BestHeight int
+	BestWork   []byte
+	AddrFrom   string
+}
+
+// CmdToBytes pads the command name to the fixed command length.
+func CmdToBytes(cmd string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range []byte(cmd) {
+		bytes[i] = c
+	}
+
+	return bytes[:]
+}
+
+// BytesToCmd strips the padding added by CmdToBytes.
+func BytesToCmd(bytes []byte) string {
+	var cmd []byte
+
+	for _, b := range bytes {
+		if b != 0x0 {
+			cmd = append(cmd, b)
+		}
+	}
+
+	return string(cmd)
+}
+
+// GobEncode serializes a message payload.
+func GobEncode(data interface{}) []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func gobDecode(payload []byte, data interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(payload))
+	if err := dec.Decode(data); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+
+	return nil
+}
+
+// NewMessage builds the bytes sent on the wire: the fixed size command followed by the gob payload.
+func NewMessage(cmd string, payload interface{}) []byte {
+	return append(CmdToBytes(cmd), GobEncode(payload)...)
+}
@@ -0,0 +1,679 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/blockchain"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// readTimeout bounds the time a peer has to send its message, a stalled connection does not hold up Stop.
+	readTimeout    = 30 * time.Second
+	maxMessageSize = 32 << 20
+	maxBlockTxs    = 1000
+)
+
+// Node is a participant of the network. It owns a chain, keeps track of the nodes it knows about and relays blocks
+// and transactions between them. Every piece of state lives on the node so several nodes can run in one process.
+type Node struct {
+	Address      string
+	MinerAddress string
+	Chain        *blockchain.BlockChain
+	Mempool      *blockchain.Mempool
+	Miner        *blockchain.Miner
+
+	mu              sync.Mutex
+	knownNodes      []string
+	blocksInTransit [][]byte
+	cancelMining    context.CancelFunc
+	stopped         bool
+	// outbox holds the messages queued under mu, they are sent once it is released.
+	outbox []message
+	// conns are the connections being read, Stop cuts them short.
+	conns map[net.Conn]struct{}
+
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+type message struct {
+	addr string
+	data []byte
+}
+
+// NewNode creates a node listening on address. Seeds are the nodes it introduces itself to when started.
+// When minerAddress is set the node mines the transactions it receives and sends the reward to that address.
+func NewNode(address, minerAddress string, chain *blockchain.BlockChain, seeds []string) *Node {
+	node := &Node{
+		Address:      address,
+		MinerAddress: minerAddress,
+		Chain:        chain,
+		Mempool:      blockchain.NewMempool(&blockchain.UTXOSet{Blockchain: chain}, blockchain.DefaultMempoolOptions),
+		Miner:        &blockchain.Miner{},
+		conns:        make(map[net.Conn]struct{}),
+	}
+
+	for _, seed := range seeds {
+		if seed != address {
+			node.knownNodes = append(node.knownNodes, seed)
+		}
+	}
+
+	return node
+}
+
+// Start opens the listener and sends a version message to the seeds. Connections are served in the background until
+// Stop is called. Listening on port 0 picks a free port, Address is then updated with the real one.
+func (n *Node) Start() error {
+	ln, err := net.Listen(protocol, n.Address)
+	if err != nil {
+		return err
+	}
+
+	n.listener = ln
+	n.Address = ln.Addr().String()
+
+	n.wg.Add(1)
+	go n.serve()
+
+	n.mu.Lock()
+	for _, node := range n.knownNodes {
+		n.SendVersion(node)
+	}
+	n.unlockAndSend()
+
+	return nil
+}
+
+// Stop closes the listener, aborts mining and waits for in-flight connections to be handled. Connections still
+// being read are abandoned.
+func (n *Node) Stop() error {
+	n.mu.Lock()
+	n.stopped = true
+	if n.cancelMining != nil {
+		n.cancelMining()
+	}
+	for conn := range n.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	n.mu.Unlock()
+
+	err := n.listener.Close()
+	n.wg.Wait()
+
+	return err
+}
+
+// KnownNodes returns a copy of the addresses this node talks to.
+func (n *Node) KnownNodes() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.knownNodes...)
+}
+
+// BestHeight returns the height of the node chain.
+func (n *Node) BestHeight() (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.Chain.GetBestHeight()
+}
+
+// SubmitTx verifies a local transaction, adds it to the memory pool and announces it to the known nodes.
+func (n *Node) SubmitTx(tx *blockchain.Transaction) error {
+	n.mu.Lock()
+	defer n.unlockAndSend()
+
+	if err := n.Mempool.Add(tx); err != nil {
+		return err
+	}
+
+	for _, node := range n.knownNodes {
+		n.SendInv(node, "tx", [][]byte{tx.ID})
+	}
+
+	n.mineTx()
+
+	return nil
+}
+
+func (n *Node) serve() {
+	defer n.wg.Done()
+
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		// the deadline is set before the connection is registered, so the one set by Stop wins.
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		n.conns[conn] = struct{}{}
+		n.mu.Unlock()
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.HandleConnection(conn)
+		}()
+	}
+}
+
+// HandleConnection reads a single message from the connection and dispatches it on its command. The answers are
+// sent once the node lock is released.
+func (n *Node) HandleConnection(conn net.Conn) {
+	req, err := readMessage(conn)
+	conn.Close()
+
+	n.mu.Lock()
+	delete(n.conns, conn)
+	n.mu.Unlock()
+
+	if err != nil {
+		log.Printf("%s: read: %v", n.Address, err)
+		return
+	}
+	if len(req) < commandLength {
+		log.Printf("%s: message too short", n.Address)
+		return
+	}
+
+	command := BytesToCmd(req[:commandLength])
+	payload := req[commandLength:]
+
+	n.mu.Lock()
+	defer n.unlockAndSend()
+
+	switch command {
+	case "addr":
+		err = n.HandleAddr(payload)
+	case "block":
+		err = n.HandleBlock(payload)
+	case "inv":
+		err = n.HandleInv(payload)
+	case "getblocks":
+		err = n.HandleGetBlocks(payload)
+	case "getdata":
+		err = n.HandleGetData(payload)
+	case "tx":
+		err = n.HandleTx(payload)
+	case "version":
+		err = n.HandleVersion(payload)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+
+	if err != nil {
+		log.Printf("%s: %s: %v", n.Address, command, err)
+	}
+}
+
+// readMessage reads a whole message, up to maxMessageSize bytes.
+func readMessage(conn net.Conn) ([]byte, error) {
+	req, err := io.ReadAll(io.LimitReader(conn, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(req) > maxMessageSize {
+		return nil, fmt.Errorf("message larger than %d bytes", maxMessageSize)
+	}
+
+	return req, nil
+}
+
+// HandleAddr records the nodes received and introduces this node to the new ones.
+func (n *Node) HandleAddr(request []byte) error {
+	var payload Addr
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	for _, addr := range payload.AddrList {
+		if addr != n.Address && !n.isKnown(addr) {
+			n.knownNodes = append(n.knownNodes, addr)
+			n.SendVersion(addr)
+		}
+	}
+
+	return nil
+}
+
+// HandleBlock stores a block and asks for the next one still in transit. A block that was not part of a sync
+// extends the chain of the other nodes as well, so it is announced to them.
+func (n *Node) HandleBlock(request []byte) error {
+	var payload Block
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	block, err := blockchain.Deserialize(payload.Block)
+	if err != nil {
+		return err
+	}
+
+	inTransit := len(n.blocksInTransit) > 0 && bytes.Equal(n.blocksInTransit[0], block.Hash)
+	if inTransit {
+		n.blocksInTransit = n.blocksInTransit[1:]
+	}
+
+	if n.Chain.HasBlock(block.Hash) {
+		return n.requestNextBlock(payload.AddrFrom)
+	}
+
+	if len(block.PrevHash) != 0 && !n.Chain.HasBlock(block.PrevHash) {
+		// This node is more than one block behind, sync the whole chain instead.
+		n.blocksInTransit = nil
+		n.SendGetBlocks(payload.AddrFrom)
+
+		return nil
+	}
+
+	change, err := n.Chain.ImportBlock(block)
+	if err != nil {
+		n.blocksInTransit = nil
+		return err
+	}
+
+	if len(change.Connected) != 0 {
+		n.applyTipChange(change)
+
+		if !inTransit {
+			for _, node := range n.knownNodes {
+				if node != payload.AddrFrom {
+					n.SendInv(node, "block", [][]byte{block.Hash})
+				}
+			}
+		}
+	}
+
+	return n.requestNextBlock(payload.AddrFrom)
+}
+
+func (n *Node) requestNextBlock(addr string) error {
+	if len(n.blocksInTransit) > 0 {
+		n.SendGetData(addr, "block", n.blocksInTransit[0])
+		return nil
+	}
+
+	// The sync is over, compare the chains again in case the other node moved on in the meantime.
+	n.SendVersion(addr)
+
+	return nil
+}
+
+// HandleInv requests the announced items this node does not have yet. Blocks are requested one at a time, oldest
+// first, so every block arrives after its parent.
+func (n *Node) HandleInv(request []byte) error {
+	var payload Inv
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	switch payload.Type {
+	case "block":
+		var missing [][]byte
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if !n.Chain.HasBlock(payload.Items[i]) {
+				missing = append(missing, payload.Items[i])
+			}
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		n.blocksInTransit = missing
+		n.SendGetData(payload.AddrFrom, "block", missing[0])
+	case "tx":
+		for _, txID := range payload.Items {
+			if !n.Mempool.Has(txID) {
+				n.SendGetData(payload.AddrFrom, "tx", txID)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown inventory type %q", payload.Type)
+	}
+
+	return nil
+}
+
+// HandleGetBlocks answers with the hashes of the whole chain.
+func (n *Node) HandleGetBlocks(request []byte) error {
+	var payload GetBlocks
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	hashes, err := n.Chain.GetBlockHashes()
+	if err != nil {
+		return err
+	}
+
+	n.SendInv(payload.AddrFrom, "block", hashes)
+
+	return nil
+}
+
+// HandleGetData sends the requested block or transaction.
+func (n *Node) HandleGetData(request []byte) error {
+	var payload GetData
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	switch payload.Type {
+	case "block":
+		block, err := n.Chain.GetBlock(payload.ID)
+		if err != nil {
+			return err
+		}
+
+		n.SendBlock(payload.AddrFrom, &block)
+	case "tx":
+		tx, ok := n.Mempool.Get(payload.ID)
+		if !ok {
+			return fmt.Errorf("transaction %x is not in the memory pool", payload.ID)
+		}
+
+		n.SendTx(payload.AddrFrom, tx)
+	default:
+		return fmt.Errorf("unknown data type %q", payload.Type)
+	}
+
+	return nil
+}
+
+// HandleTx verifies a transaction, keeps it in the memory pool and relays it to the other nodes.
+func (n *Node) HandleTx(request []byte) error {
+	var payload Tx
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	tx, err := blockchain.DeserializeTransaction(payload.Transaction)
+	if err != nil {
+		return err
+	}
+
+	if n.Mempool.Has(tx.ID) {
+		return nil
+	}
+
+	if err := n.Mempool.Add(&tx); err != nil {
+		return err
+	}
+
+	for _, node := range n.knownNodes {
+		if node != payload.AddrFrom {
+			n.SendInv(node, "tx", [][]byte{tx.ID})
+		}
+	}
+
+	n.mineTx()
+
+	return nil
+}
+
+// HandleVersion compares the cumulative work of the chains, which the fork choice rule goes by: the node with the
+// lighter chain asks the other one for its blocks.
+func (n *Node) HandleVersion(request []byte) error {
+	var payload Version
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	if payload.Version != protocolVersion {
+		return fmt.Errorf("unsupported protocol version %d from %s", payload.Version, payload.AddrFrom)
+	}
+
+	if !n.isKnown(payload.AddrFrom) {
+		n.knownNodes = append(n.knownNodes, payload.AddrFrom)
+		n.SendAddr(payload.AddrFrom)
+	}
+
+	bestWork, err := n.Chain.CumulativeWork(n.Chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	switch bestWork.Cmp(new(big.Int).SetBytes(payload.BestWork)) {
+	case -1:
+		n.SendGetBlocks(payload.AddrFrom)
+	case 1:
+		n.SendVersion(payload.AddrFrom)
+	}
+
+	return nil
+}
+
+// mineTx starts mining the transactions of the memory pool when this node is a miner. The proof of work is searched
+// in the background without holding the node lock, and is cancelled when another node extends the tip first.
+func (n *Node) mineTx() {
+	if n.MinerAddress == "" || n.stopped || n.cancelMining != nil || n.Mempool.Count() == 0 {
+		return
+	}
+
+	header, err := n.Chain.NextHeader()
+	if err != nil {
+		log.Printf("%s: mining: %v", n.Address, err)
+		return
+	}
+	txs := n.Mempool.BlockTemplate(n.MinerAddress, header.Height, maxBlockTxs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancelMining = cancel
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		newBlock, err := n.Miner.Mine(ctx, header, txs)
+
+		n.mu.Lock()
+		defer n.unlockAndSend()
+
+		cancel()
+		n.cancelMining = nil
+
+		if err == nil {
+			n.addMinedBlock(newBlock)
+		} else if !errors.Is(err, context.Canceled) {
+			log.Printf("%s: mining: %v", n.Address, err)
+		}
+
+		// more transactions may have arrived, or the tip moved and the template has to be rebuilt.
+		n.mineTx()
+	}()
+}
+
+func (n *Node) addMinedBlock(newBlock *blockchain.Block) {
+	change, err := n.Chain.ImportBlock(newBlock)
+	if err != nil {
+		log.Printf("%s: mined block: %v", n.Address, err)
+		return
+	}
+	if len(change.Connected) == 0 {
+		return
+	}
+
+	n.applyTipChange(change)
+
+	for _, node := range n.knownNodes {
+		n.SendInv(node, "block", [][]byte{newBlock.Hash})
+	}
+}
+
+// applyTipChange brings the mempool in line with a new main chain. Transactions of disconnected blocks go back to
+// the pool when they are still valid, transactions of connected blocks leave it.
+func (n *Node) applyTipChange(change blockchain.TipChange) {
+	for _, block := range change.Connected {
+		n.Mempool.RemoveBlock(block)
+	}
+
+	for i := len(change.Disconnected) - 1; i >= 0; i-- {
+		for _, tx := range change.Disconnected[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			if err := n.Mempool.Add(tx); err == nil {
+				log.Printf("%s: transaction %x back in the mempool", n.Address, tx.ID)
+			}
+		}
+	}
+
+	// the block being mined no longer extends the tip.
+	if n.cancelMining != nil {
+		n.cancelMining()
+	}
+}
+
+func (n *Node) isKnown(addr string) bool {
+	for _, node := range n.knownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SendAddr shares the known nodes with addr.
+func (n *Node) SendAddr(addr string) {
+	nodes := Addr{append(append([]string(nil), n.knownNodes...), n.Address)}
+
+	n.SendData(addr, NewMessage("addr", nodes))
+}
+
+// SendBlock sends a whole block to addr.
+func (n *Node) SendBlock(addr string, b *blockchain.Block) {
+	data := Block{n.Address, b.Serialize()}
+
+	n.SendData(addr, NewMessage("block", data))
+}
+
+// SendInv announces blocks or transactions to addr.
+func (n *Node) SendInv(addr, kind string, items [][]byte) {
+	inventory := Inv{n.Address, kind, items}
+
+	n.SendData(addr, NewMessage("inv", inventory))
+}
+
+// SendTx sends a whole transaction to addr.
+func (n *Node) SendTx(addr string, tnx *blockchain.Transaction) {
+	data := Tx{n.Address, tnx.Serialize()}
+
+	n.SendData(addr, NewMessage("tx", data))
+}
+
+// SendVersion sends the handshake message to addr.
+func (n *Node) SendVersion(addr string) {
+	bestHeight, err := n.Chain.GetBestHeight()
+	if err != nil {
+		log.Printf("%s: %v", n.Address, err)
+		return
+	}
+	bestWork, err := n.Chain.CumulativeWork(n.Chain.LastHash)
+	if err != nil {
+		log.Printf("%s: %v", n.Address, err)
+		return
+	}
+
+	n.SendData(addr, NewMessage("version", Version{protocolVersion, bestHeight, bestWork.Bytes(), n.Address}))
+}
+
+// SendGetBlocks asks addr for the hashes of its blocks.
+func (n *Node) SendGetBlocks(addr string) {
+	n.SendData(addr, NewMessage("getblocks", GetBlocks{n.Address}))
+}
+
+// SendGetData asks addr for a block or a transaction.
+func (n *Node) SendGetData(addr, kind string, id []byte) {
+	n.SendData(addr, NewMessage("getdata", GetData{n.Address, kind, id}))
+}
+
+// SendTxTo hands a transaction to the node at addr without running a node locally.
+func SendTxTo(addr string, tx *blockchain.Transaction) error {
+	return sendData(addr, NewMessage("tx", Tx{"", tx.Serialize()}))
+}
+
+func sendData(addr string, data []byte) error {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return writeMessage(conn, data)
+}
+
+func writeMessage(conn net.Conn, data []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(readTimeout)); err != nil {
+		return err
+	}
+	_, err := io.Copy(conn, bytes.NewReader(data))
+
+	return err
+}
+
+// SendData queues a message to addr. It is called with the node lock held, the message is sent by unlockAndSend.
+func (n *Node) SendData(addr string, data []byte) {
+	n.outbox = append(n.outbox, message{addr, data})
+}
+
+// unlockAndSend releases the node lock and then sends the queued messages, so a slow peer never blocks the node.
+func (n *Node) unlockAndSend() {
+	outbox := n.outbox
+	n.outbox = nil
+	n.mu.Unlock()
+
+	for _, msg := range outbox {
+		n.send(msg.addr, msg.data)
+	}
+}
+
+// send writes a message to addr. A node that cannot be reached is forgotten.
+func (n *Node) send(addr string, data []byte) {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		log.Printf("%s: %s is not available", n.Address, addr)
+		n.forget(addr)
+
+		return
+	}
+	defer conn.Close()
+
+	if err := writeMessage(conn, data); err != nil {
+		log.Printf("%s: send to %s: %v", n.Address, addr, err)
+	}
+}
+
+func (n *Node) forget(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var updatedNodes []string
+	for _, node := range n.knownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+	n.knownNodes = updatedNodes
+}
@@ -0,0 +1,386 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/blockchain"
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+// newChain creates a regtest chain in memory, its genesis block paying w.
+func newChain(t *testing.T, w *wallet.Wallet) *blockchain.BlockChain {
+	t.Helper()
+
+	chain, err := blockchain.InitBlockChain(string(w.Address()),
+		blockchain.Options{Network: "regtest", Store: storage.NewMemoryStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain
+}
+
+type peerMessage struct {
+	command string
+	payload []byte
+}
+
+// peer is a scripted node: it records the messages sent to it and sends the messages of the test.
+type peer struct {
+	ln       net.Listener
+	messages chan peerMessage
+}
+
+func newPeer(t *testing.T) *peer {
+	t.Helper()
+
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	p := &peer{ln: ln, messages: make(chan peerMessage, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			if len(data) >= commandLength {
+				p.messages <- peerMessage{BytesToCmd(data[:commandLength]), data[commandLength:]}
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *peer) address() string {
+	return p.ln.Addr().String()
+}
+
+func (p *peer) send(t *testing.T, node *Node, command string, payload interface{}) {
+	t.Helper()
+
+	conn, err := net.Dial(protocol, node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(NewMessage(command, payload)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect waits for the next message sent to the peer and decodes its payload into data.
+func (p *peer) expect(t *testing.T, command string, data interface{}) {
+	t.Helper()
+
+	select {
+	case msg := <-p.messages:
+		if msg.command != command {
+			t.Fatalf("received %s, want %s", msg.command, command)
+		}
+		if err := gobDecode(msg.payload, data); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatalf("timed out waiting for %s", command)
+	}
+}
+
+// cloneChain copies a chain into a new memory store, so several nodes start from the same genesis block.
+func cloneChain(t *testing.T, chain *blockchain.BlockChain) *blockchain.BlockChain {
+	t.Helper()
+
+	store := storage.NewMemoryStore()
+	err := chain.Database.Iterate(nil, func(key, value []byte) error {
+		return store.Put(key, value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := blockchain.ContinueBlockChain(blockchain.Options{Network: "regtest", Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clone
+}
+
+func startNode(t *testing.T, minerAddress string, chain *blockchain.BlockChain, seeds ...string) *Node {
+	t.Helper()
+
+	node := NewNode("127.0.0.1:0", minerAddress, chain, seeds)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Stop() })
+
+	return node
+}
+
+func TestNodeServesChain(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newChain(t, w)
+	for i := 0; i < 2; i++ {
+		if _, err := chain.AddBlock([]*blockchain.Transaction{blockchain.CoinBaseTx(string(w.Address()), "", 20)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newPeer(t)
+	node := startNode(t, "", chain)
+
+	// a new node is told the known nodes, then the longer chain answers the handshake with its own height.
+	p.send(t, node, "version", Version{protocolVersion, 0, nil, p.address()})
+	var addr Addr
+	p.expect(t, "addr", &addr)
+	var version Version
+	p.expect(t, "version", &version)
+	if version.BestHeight != 2 || version.AddrFrom != node.Address {
+		t.Fatalf("version %+v, want height 2 from %s", version, node.Address)
+	}
+
+	p.send(t, node, "getblocks", GetBlocks{p.address()})
+	var inv Inv
+	p.expect(t, "inv", &inv)
+	if inv.Type != "block" || len(inv.Items) != 3 || !bytes.Equal(inv.Items[0], chain.LastHash) {
+		t.Fatalf("inventory %s of %d items, want the 3 blocks from the tip", inv.Type, len(inv.Items))
+	}
+
+	genesis := inv.Items[2]
+	p.send(t, node, "getdata", GetData{p.address(), "block", genesis})
+	var block Block
+	p.expect(t, "block", &block)
+	got, err := blockchain.Deserialize(block.Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Hash, genesis) {
+		t.Errorf("received block %x, want %x", got.Hash, genesis)
+	}
+}
+
+func TestNodeImportsAnnouncedBlock(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain := newChain(t, w)
+
+	p := newPeer(t)
+	node := startNode(t, "", chain, p.address())
+
+	var version Version
+	p.expect(t, "version", &version)
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := blockchain.BlockHeader{
+		PrevHash:  genesis.Hash,
+		Timestamp: genesis.Timestamp + 1,
+		Bits:      genesis.Bits,
+		Height:    1,
+	}
+	next, err := blockchain.CreateBlock(header, []*blockchain.Transaction{blockchain.CoinBaseTx(string(w.Address()), "", 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.send(t, node, "inv", Inv{p.address(), "block", [][]byte{next.Hash}})
+
+	var getData GetData
+	p.expect(t, "getdata", &getData)
+	if getData.Type != "block" || !bytes.Equal(getData.ID, next.Hash) {
+		t.Fatalf("requested %s %x, want block %x", getData.Type, getData.ID, next.Hash)
+	}
+
+	p.send(t, node, "block", Block{p.address(), next.Serialize()})
+	p.expect(t, "version", &version)
+	if height, err := node.BestHeight(); err != nil || version.BestHeight != 1 || height != 1 {
+		t.Errorf("height %d, %v after the block, want 1", height, err)
+	}
+	if !bytes.Equal(chain.LastHash, next.Hash) {
+		t.Errorf("tip %x, want %x", chain.LastHash, next.Hash)
+	}
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(20 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func atHeight(nodes []*Node, height int) func() bool {
+	return func() bool {
+		for _, node := range nodes {
+			if h, err := node.BestHeight(); err != nil || h != height {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func TestNodesSyncChain(t *testing.T) {
+	w := wallet.MakeWallet()
+	chainA := newChain(t, w)
+	chainB := cloneChain(t, chainA)
+	chainC := cloneChain(t, chainA)
+
+	for i := 0; i < 3; i++ {
+		if _, err := chainA.AddBlock([]*blockchain.Transaction{blockchain.CoinBaseTx(string(w.Address()), "", 20)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := startNode(t, "", chainA)
+	b := startNode(t, "", chainB, a.Address)
+	c := startNode(t, "", chainC, b.Address)
+
+	waitFor(t, "the chains to sync", atHeight([]*Node{a, b, c}, 3))
+
+	for _, node := range []*Node{b, c} {
+		node.mu.Lock()
+		lastHash := node.Chain.LastHash
+		node.mu.Unlock()
+
+		if string(lastHash) != string(chainA.LastHash) {
+			t.Errorf("%s tip %x, want %x", node.Address, lastHash, chainA.LastHash)
+		}
+	}
+}
+
+func TestNodesFollowHeavierBranch(t *testing.T) {
+	w := wallet.MakeWallet()
+	chainA := newChain(t, w)
+	chainB := cloneChain(t, chainA)
+
+	for i := 0; i < 3; i++ {
+		if _, err := chainA.AddBlock([]*blockchain.Transaction{blockchain.CoinBaseTx(string(w.Address()), "", 20)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := chainB.AddBlock([]*blockchain.Transaction{blockchain.CoinBaseTx(string(w.Address()), "", 20)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := startNode(t, "", chainA)
+	b := startNode(t, "", chainB, a.Address)
+
+	waitFor(t, "the lighter branch to be replaced", atHeight([]*Node{a, b}, 3))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if string(chainB.LastHash) != string(chainA.LastHash) {
+		t.Errorf("tip %x, want %x", chainB.LastHash, chainA.LastHash)
+	}
+}
+
+func TestNodesRelayAndMineTx(t *testing.T) {
+	w := wallet.MakeWallet()
+	to := wallet.MakeWallet()
+	chainA := newChain(t, w)
+	chainB := cloneChain(t, chainA)
+	chainC := cloneChain(t, chainA)
+
+	a := startNode(t, "", chainA)
+	b := startNode(t, string(w.Address()), chainB, a.Address)
+	c := startNode(t, "", chainC, b.Address)
+
+	waitFor(t, "the nodes to know each other", func() bool {
+		return len(a.KnownNodes()) == 2 && len(c.KnownNodes()) == 2
+	})
+
+	a.mu.Lock()
+	tx, err := blockchain.NewTransaction(w, string(to.Address()), 5, &blockchain.UTXOSet{Blockchain: chainA},
+		blockchain.TxOptions{Fee: 1})
+	a.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SubmitTx(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the transaction to be mined", atHeight([]*Node{a, b, c}, 1))
+	waitFor(t, "the memory pools to empty", func() bool {
+		for _, node := range []*Node{a, b, c} {
+			node.mu.Lock()
+			count := node.Mempool.Count()
+			node.mu.Unlock()
+			if count != 0 {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found, err := chainC.FindTx(tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(found.ID) != string(tx.ID) {
+		t.Errorf("found transaction %x, want %x", found.ID, tx.ID)
+	}
+}
+
+func TestStopAbandonsStalledConnection(t *testing.T) {
+	node := startNode(t, "", newChain(t, wallet.MakeWallet()))
+
+	conn, err := net.Dial(protocol, node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// give the node time to accept the connection, which never sends its message.
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- node.Stop() }()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waits for a stalled connection")
+	}
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		client.Write(make([]byte, maxMessageSize+1))
+		client.Close()
+	}()
+
+	if _, err := readMessage(server); err == nil {
+		t.Fatal("oversized message accepted")
+	}
+}
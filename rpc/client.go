@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Client calls the methods of a Server. The zero HTTPClient uses http.DefaultClient.
+type Client struct {
+	nextID int64 // first for the 64-bit alignment atomic operations need
+
+	URL        string
+	User       string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a client for the server at url, http://host:port.
+func NewClient(url, user, password string) *Client {
+	return &Client{URL: url, User: user, Password: password}
+}
+
+// Call invokes method with positional params and decodes the result into result, which may be nil. A failed call
+// returns the *Error of the server.
+func (c *Client) Call(method string, result interface{}, params ...interface{}) error {
+	request := struct {
+		JSONRPC string        `json:"jsonrpc"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+		ID      int64         `json:"id"`
+	}{jsonRPCVersion, method, params, atomic.AddInt64(&c.nextID, 1)}
+	if request.Params == nil {
+		request.Params = []interface{}{}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if c.User != "" {
+		httpRequest.SetBasicAuth(c.User, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc %s: %s", method, httpResponse.Status)
+	}
+
+	var response Response
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if string(response.ID) != strconv.FormatInt(request.ID, 10) {
+		return fmt.Errorf("rpc %s: response id %s does not match request id %d", method, response.ID, request.ID)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// GetChainInfo returns the state of the chain.
+func (c *Client) GetChainInfo() (ChainInfo, error) {
+	var info ChainInfo
+	err := c.Call("getchaininfo", &info)
+
+	return info, err
+}
+
+// GetBlockHash returns the hash of the main chain block at height.
+func (c *Client) GetBlockHash(height int) (string, error) {
+	var hash string
+	err := c.Call("getblockhash", &hash, height)
+
+	return hash, err
+}
+
+// GetBlock returns the block with the given hex hash.
+func (c *Client) GetBlock(hash string) (BlockInfo, error) {
+	var info BlockInfo
+	err := c.Call("getblock", &info, hash)
+
+	return info, err
+}
+
+// GetTransaction returns a transaction of the main chain or of the memory pool.
+func (c *Client) GetTransaction(txid string) (TxInfo, error) {
+	var info TxInfo
+	err := c.Call("gettransaction", &info, txid)
+
+	return info, err
+}
+
+// GetBalance returns the balance of an address, or of every wallet of the server when address is empty.
+func (c *Client) GetBalance(address string) (int, error) {
+	var balance int
+	err := c.Call("getbalance", &balance, optional(address)...)
+
+	return balance, err
+}
+
+// ListUnspent returns the unspent outputs of an address, or of every wallet of the server when address is empty.
+func (c *Client) ListUnspent(address string) ([]Unspent, error) {
+	var unspent []Unspent
+	err := c.Call("listunspent", &unspent, optional(address)...)
+
+	return unspent, err
+}
+
+// GetNewAddress creates a wallet on the server and returns its address.
+func (c *Client) GetNewAddress() (string, error) {
+	var address string
+	err := c.Call("getnewaddress", &address)
+
+	return address, err
+}
+
+// SendToAddress pays amount to the to address from the wallet of the from address, leaving fee to the miner, and
+// returns the transaction ID.
+func (c *Client) SendToAddress(to string, amount int, from string, fee int) (string, error) {
+	var txid string
+	err := c.Call("sendtoaddress", &txid, to, amount, from, fee)
+
+	return txid, err
+}
+
+// EncryptWallet encrypts the wallet file of the server with passphrase and locks it.
+func (c *Client) EncryptWallet(passphrase string) error {
+	return c.Call("encryptwallet", nil, passphrase)
+}
+
+// WalletPassphrase unlocks the wallet file of the server for timeout seconds.
+func (c *Client) WalletPassphrase(passphrase string, timeout int) error {
+	return c.Call("walletpassphrase", nil, passphrase, timeout)
+}
+
+// WalletLock locks the wallet file of the server.
+func (c *Client) WalletLock() error {
+	return c.Call("walletlock", nil)
+}
+
+func optional(param string) []interface{} {
+	if param == "" {
+		return nil
+	}
+
+	return []interface{}{param}
+}
@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const jsonRPCVersion = "2.0"
+
+// Error codes of the JSON-RPC 2.0 specification, followed by the ones of this server.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	CodeInvalidAddress       = -5
+	CodeNotFound             = -6
+	CodeInsufficientFunds    = -7
+	CodeWalletLocked         = -13
+	CodeWrongPassphrase      = -14
+	CodeWrongEncryptionState = -15
+	CodeTxRejected           = -26
+)
+
+// Request is a JSON-RPC 2.0 call. Params are positional.
+type Request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+}
+
+// Response carries either the result or the error of a call.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is the error object of a response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// ChainInfo is the result of getchaininfo.
+type ChainInfo struct {
+	Chain         string `json:"chain"`
+	Blocks        int    `json:"blocks"`
+	BestBlockHash string `json:"bestblockhash"`
+	Bits          string `json:"bits"`
+	ChainWork     string `json:"chainwork"`
+	MedianTime    int64  `json:"mediantime"`
+	Mempool       int    `json:"mempool"`
+}
+
+// BlockInfo is the result of getblock.
+type BlockInfo struct {
+	Hash          string   `json:"hash"`
+	Confirmations int      `json:"confirmations"`
+	Height        int      `json:"height"`
+	Version       int      `json:"version"`
+	MerkleRoot    string   `json:"merkleroot"`
+	WitnessRoot   string   `json:"witnessroot,omitempty"`
+	Time          int64    `json:"time"`
+	Nonce         int      `json:"nonce"`
+	Bits          string   `json:"bits"`
+	PreviousHash  string   `json:"previousblockhash,omitempty"`
+	Tx            []string `json:"tx"`
+}
+
+// TxInputInfo describes an input of a transaction.
+type TxInputInfo struct {
+	TxID      string `json:"txid,omitempty"`
+	Vout      int    `json:"vout"`
+	Coinbase  string `json:"coinbase,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	PubKey    string `json:"pubkey,omitempty"`
+	ScriptSig string `json:"scriptsig,omitempty"`
+	Sequence  uint32 `json:"sequence,omitempty"`
+}
+
+// TxOutputInfo describes an output of a transaction.
+type TxOutputInfo struct {
+	Value      int    `json:"value"`
+	N          int    `json:"n"`
+	PubKeyHash string `json:"pubkeyhash,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Script     string `json:"script"`
+}
+
+// TxInfo is the result of gettransaction. BlockHash is empty for a transaction of the memory pool.
+type TxInfo struct {
+	TxID          string         `json:"txid"`
+	BlockHash     string         `json:"blockhash,omitempty"`
+	Confirmations int            `json:"confirmations"`
+	LockTime      int64          `json:"locktime,omitempty"`
+	Vin           []TxInputInfo  `json:"vin"`
+	Vout          []TxOutputInfo `json:"vout"`
+}
+
+// Unspent is an entry of the listunspent result.
+type Unspent struct {
+	TxID    string `json:"txid"`
+	Vout    int    `json:"vout"`
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
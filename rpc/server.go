@@ -0,0 +1,701 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/blockchain"
+	"github.com/tensor-programming/golang-blockchain/network"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+const (
+	maxRequestSize  = 1 << 20
+	shutdownTimeout = 5 * time.Second
+)
+
+// ErrNoCredentials is returned by Start when the server has no user or no password.
+var ErrNoCredentials = errors.New("rpc server needs a user and a password")
+
+type handler func(s *Server, params []json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"encryptwallet":    (*Server).encryptWallet,
+	"getblock":         (*Server).getBlock,
+	"getblockhash":     (*Server).getBlockHash,
+	"getbalance":       (*Server).getBalance,
+	"getchaininfo":     (*Server).getChainInfo,
+	"getnewaddress":    (*Server).getNewAddress,
+	"gettransaction":   (*Server).getTransaction,
+	"listunspent":      (*Server).listUnspent,
+	"sendtoaddress":    (*Server).sendToAddress,
+	"walletlock":       (*Server).walletLock,
+	"walletpassphrase": (*Server).walletPassphrase,
+}
+
+// Server answers JSON-RPC 2.0 calls over HTTP POST, backed by a chain and a set of wallets. Calls are served one at
+// a time. Every request must carry User and Password with HTTP basic authentication and be sent as
+// application/json, which a browser cannot do on behalf of another site without the consent of the server.
+type Server struct {
+	Address  string
+	User     string
+	Password string
+	Chain    *blockchain.BlockChain
+	Wallets  *wallet.Wallets
+	Mempool  *blockchain.Mempool
+	// NodeAddress is the node sendtoaddress hands transactions to. When empty the server mines them itself.
+	NodeAddress string
+
+	mu        sync.Mutex
+	server    *http.Server
+	wg        sync.WaitGroup
+	lockTimer *time.Timer // locks the wallets when the walletpassphrase timeout expires
+}
+
+// NewServer creates a server listening on address.
+func NewServer(address string, chain *blockchain.BlockChain, wallets *wallet.Wallets) *Server {
+	return &Server{
+		Address: address,
+		Chain:   chain,
+		Wallets: wallets,
+		Mempool: blockchain.NewMempool(&blockchain.UTXOSet{Blockchain: chain}, blockchain.DefaultMempoolOptions),
+	}
+}
+
+// Start listens on the server address and serves requests in the background. Address is updated with the port the
+// system picked when it was 0. It returns ErrNoCredentials when User or Password is empty.
+func (s *Server) Start() error {
+	if s.User == "" || s.Password == "" {
+		return ErrNoCredentials
+	}
+
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+	s.Address = ln.Addr().String()
+	s.server = &http.Server{Handler: s}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("rpc %s: %v", s.Address, err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop closes the listener and waits for the requests being served.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	if s.lockTimer != nil {
+		s.lockTimer.Stop()
+	}
+	s.mu.Unlock()
+
+	return err
+}
+
+// ServeHTTP handles a single call or a batch of calls.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			result = errorResponse(nil, &Error{CodeInvalidRequest, "invalid batch"})
+		} else {
+			var responses []*Response
+			for _, raw := range batch {
+				if response := s.handle(raw); response != nil {
+					responses = append(responses, response)
+				}
+			}
+			if len(responses) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			result = responses
+		}
+	} else {
+		response := s.handle(body)
+		if response == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		result = response
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("rpc %s: %v", s.Address, err)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.User == "" {
+		return false
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.User)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.Password)) == 1
+
+	return userOK && passwordOK
+}
+
+// handle runs one call. Notifications, calls without an ID, get no response.
+func (s *Server) handle(raw json.RawMessage) *Response {
+	var request Request
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return errorResponse(nil, &Error{CodeParseError, err.Error()})
+	}
+
+	if request.JSONRPC != jsonRPCVersion || request.Method == "" {
+		return errorResponse(request.ID, &Error{CodeInvalidRequest, "invalid request"})
+	}
+
+	h, ok := handlers[request.Method]
+	if !ok {
+		if request.ID == nil {
+			return nil
+		}
+		return errorResponse(request.ID, &Error{CodeMethodNotFound, fmt.Sprintf("method %q not found", request.Method)})
+	}
+
+	result, err := s.call(h, request.Params)
+
+	if request.ID == nil {
+		return nil
+	}
+
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{CodeInternalError, err.Error()}
+		}
+		return errorResponse(request.ID, rpcErr)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(request.ID, &Error{CodeInternalError, err.Error()})
+	}
+
+	return &Response{JSONRPC: jsonRPCVersion, Result: encoded, ID: request.ID}
+}
+
+// call runs a handler holding the server lock, which a panicking handler releases too.
+func (s *Server) call(h handler, params []json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return h(s, params)
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &Response{JSONRPC: jsonRPCVersion, Error: err, ID: id}
+}
+
+func invalidParams(format string, args ...interface{}) error {
+	return &Error{CodeInvalidParams, fmt.Sprintf(format, args...)}
+}
+
+// parseParams decodes the positional params into dst. The first required params are mandatory.
+func parseParams(params []json.RawMessage, required int, dst ...interface{}) error {
+	if len(params) < required || len(params) > len(dst) {
+		return invalidParams("expected %d to %d params, got %d", required, len(dst), len(params))
+	}
+
+	for i, param := range params {
+		if err := json.Unmarshal(param, dst[i]); err != nil {
+			return invalidParams("param %d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func parseHash(param string) ([]byte, error) {
+	hash, err := hex.DecodeString(param)
+	if err != nil || len(hash) == 0 {
+		return nil, invalidParams("%q is not a hex hash", param)
+	}
+
+	return hash, nil
+}
+
+func checkAddress(address string) error {
+	if !wallet.ValidateAddress(address) {
+		return &Error{CodeInvalidAddress, fmt.Sprintf("invalid address %q", address)}
+	}
+
+	return nil
+}
+
+// blockAtHeight returns the main chain block at height.
+func (s *Server) blockAtHeight(height int) (*blockchain.Block, error) {
+	block, err := s.Chain.GetBlockByHeight(height)
+	if errors.Is(err, blockchain.ErrBlockNotFound) {
+		return nil, &Error{CodeNotFound, fmt.Sprintf("no block at height %d", height)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+// confirmations is the number of main chain blocks from the block to the tip, -1 for a side branch block.
+func (s *Server) confirmations(block *blockchain.Block) (int, error) {
+	tipHeight, err := s.Chain.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+	if block.Height > tipHeight {
+		return -1, nil
+	}
+
+	mainBlock, err := s.blockAtHeight(block.Height)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(mainBlock.Hash, block.Hash) {
+		return -1, nil
+	}
+
+	return tipHeight - block.Height + 1, nil
+}
+
+func (s *Server) getChainInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	tip, err := s.Chain.GetBlock(s.Chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	work, err := s.Chain.CumulativeWork(tip.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	medianTime, err := s.Chain.MedianTimePast(tip.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	chainName := blockchain.MainNet
+	if s.Chain.Params != nil {
+		chainName = s.Chain.Params.Name
+	}
+
+	return ChainInfo{
+		Chain:         chainName,
+		Blocks:        tip.Height,
+		BestBlockHash: hex.EncodeToString(tip.Hash),
+		Bits:          fmt.Sprintf("%08x", tip.Bits),
+		ChainWork:     work.Text(16),
+		MedianTime:    medianTime,
+		Mempool:       s.Mempool.Count(),
+	}, nil
+}
+
+func (s *Server) getBlockHash(params []json.RawMessage) (interface{}, error) {
+	var height int
+	if err := parseParams(params, 1, &height); err != nil {
+		return nil, err
+	}
+
+	block, err := s.blockAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(block.Hash), nil
+}
+
+func (s *Server) getBlock(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := parseParams(params, 1, &hash); err != nil {
+		return nil, err
+	}
+	blockHash, err := parseHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.Chain.GetBlock(blockHash)
+	if errors.Is(err, blockchain.ErrBlockNotFound) {
+		return nil, &Error{CodeNotFound, err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	confirmations, err := s.confirmations(&block)
+	if err != nil {
+		return nil, err
+	}
+
+	info := BlockInfo{
+		Hash:          hex.EncodeToString(block.Hash),
+		Confirmations: confirmations,
+		Height:        block.Height,
+		Version:       block.Version,
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		WitnessRoot:   hex.EncodeToString(block.WitnessRoot),
+		Time:          block.Timestamp,
+		Nonce:         block.Nonce,
+		Bits:          fmt.Sprintf("%08x", block.Bits),
+		PreviousHash:  hex.EncodeToString(block.PrevHash),
+		Tx:            []string{},
+	}
+	for _, tx := range block.Transactions {
+		info.Tx = append(info.Tx, hex.EncodeToString(tx.ID))
+	}
+
+	return info, nil
+}
+
+func (s *Server) getTransaction(params []json.RawMessage) (interface{}, error) {
+	var txid string
+	if err := parseParams(params, 1, &txid); err != nil {
+		return nil, err
+	}
+	ID, err := parseHash(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, ok := s.Mempool.Get(ID); ok {
+		return txInfo(tx, nil, 0), nil
+	}
+
+	tx, block, err := s.Chain.FindTxBlock(ID)
+	if errors.Is(err, blockchain.ErrTxNotFound) {
+		return nil, &Error{CodeNotFound, fmt.Sprintf("transaction %s not found", txid)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tipHeight, err := s.Chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	return txInfo(tx, block, tipHeight-block.Height+1), nil
+}
+
+func txInfo(tx *blockchain.Transaction, block *blockchain.Block, confirmations int) TxInfo {
+	info := TxInfo{
+		TxID:          hex.EncodeToString(tx.ID),
+		Confirmations: confirmations,
+		LockTime:      tx.LockTime,
+		Vin:           []TxInputInfo{},
+		Vout:          []TxOutputInfo{},
+	}
+	if block != nil {
+		info.BlockHash = hex.EncodeToString(block.Hash)
+	}
+
+	for _, in := range tx.Inputs {
+		if tx.IsCoinbase() {
+			info.Vin = append(info.Vin, TxInputInfo{Vout: in.Out, Coinbase: hex.EncodeToString(in.PubKey)})
+			continue
+		}
+		info.Vin = append(info.Vin, TxInputInfo{
+			TxID:      hex.EncodeToString(in.ID),
+			Vout:      in.Out,
+			Signature: hex.EncodeToString(in.Signature),
+			PubKey:    hex.EncodeToString(in.PubKey),
+			ScriptSig: blockchain.DisassembleScript(in.UnlockingScript()),
+			Sequence:  in.Sequence,
+		})
+	}
+
+	for i, out := range tx.Outputs {
+		outInfo := TxOutputInfo{
+			Value:  out.Value,
+			N:      i,
+			Script: blockchain.DisassembleScript(out.LockingScript()),
+		}
+		if out.Script == nil {
+			outInfo.PubKeyHash = hex.EncodeToString(out.PubKeyHash)
+			outInfo.Address = string(out.Address())
+		}
+		info.Vout = append(info.Vout, outInfo)
+	}
+
+	return info
+}
+
+func (s *Server) getBalance(params []json.RawMessage) (interface{}, error) {
+	unspent, err := s.unspent(params)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := 0
+	for _, u := range unspent {
+		balance += u.Amount
+	}
+
+	return balance, nil
+}
+
+func (s *Server) listUnspent(params []json.RawMessage) (interface{}, error) {
+	return s.unspent(params)
+}
+
+// unspent lists the outputs of the address given as the only param, or of every wallet when there is none.
+func (s *Server) unspent(params []json.RawMessage) ([]Unspent, error) {
+	var address string
+	if err := parseParams(params, 0, &address); err != nil {
+		return nil, err
+	}
+
+	addresses := []string{address}
+	if address == "" {
+		addresses = s.Wallets.GetAllAddresses()
+		sort.Strings(addresses)
+	} else if err := checkAddress(address); err != nil {
+		return nil, err
+	}
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: s.Chain}
+	result := []Unspent{}
+
+	for _, address := range addresses {
+		outputs, err := UTXOSet.ListUnspent(blockchain.PubKeyHash([]byte(address)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outputs {
+			result = append(result, Unspent{
+				TxID:    hex.EncodeToString(out.TxID),
+				Vout:    out.Index,
+				Address: address,
+				Amount:  out.Output.Value,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Server) getNewAddress(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	address, err := s.Wallets.AddWallet()
+	if err != nil {
+		return nil, walletError(err)
+	}
+	if err := s.Wallets.SaveFile(); err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// sendToAddress pays amount to an address from the outputs of one of the wallets, with an optional fee, and returns
+// the transaction ID.
+func (s *Server) sendToAddress(params []json.RawMessage) (interface{}, error) {
+	var to, from string
+	var amount, fee int
+	if err := parseParams(params, 3, &to, &amount, &from, &fee); err != nil {
+		return nil, err
+	}
+
+	if err := checkAddress(to); err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, invalidParams("amount must be positive")
+	}
+	if fee < 0 {
+		return nil, invalidParams("fee must not be negative")
+	}
+
+	w, ok := s.Wallets.Wallets[from]
+	if !ok {
+		return nil, &Error{CodeInvalidAddress, fmt.Sprintf("no wallet for address %q", from)}
+	}
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: s.Chain}
+
+	tx, err := blockchain.NewTransaction(w, to, amount, &UTXOSet, blockchain.TxOptions{Fee: fee})
+	if errors.Is(err, blockchain.ErrInsufficientFunds) {
+		return nil, &Error{CodeInsufficientFunds, err.Error()}
+	}
+	if errors.Is(err, wallet.ErrWalletLocked) {
+		return nil, walletError(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.NodeAddress != "" {
+		if err := network.SendTxTo(s.NodeAddress, tx); err != nil {
+			return nil, err
+		}
+
+		return hex.EncodeToString(tx.ID), nil
+	}
+
+	if err := s.Mempool.Add(tx); err != nil {
+		return nil, &Error{CodeTxRejected, err.Error()}
+	}
+
+	height, err := s.Chain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.Chain.AddBlock(s.Mempool.BlockTemplate(from, height+1, 0))
+	if err != nil {
+		return nil, err
+	}
+	s.Mempool.RemoveBlock(block)
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// encryptWallet encrypts the wallet file with a passphrase and locks it.
+func (s *Server) encryptWallet(params []json.RawMessage) (interface{}, error) {
+	var passphrase string
+	if err := parseParams(params, 1, &passphrase); err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, invalidParams("passphrase must not be empty")
+	}
+
+	if err := s.Wallets.Encrypt(passphrase); err != nil {
+		return nil, walletError(err)
+	}
+	if err := s.Wallets.SaveFile(); err != nil {
+		return nil, err
+	}
+
+	return "wallet encrypted", nil
+}
+
+// walletPassphrase unlocks the wallet file for timeout seconds.
+func (s *Server) walletPassphrase(params []json.RawMessage) (interface{}, error) {
+	var passphrase string
+	var timeout int
+	if err := parseParams(params, 2, &passphrase, &timeout); err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, invalidParams("timeout must be positive")
+	}
+
+	if err := s.Wallets.Unlock(passphrase); err != nil {
+		return nil, walletError(err)
+	}
+
+	if s.lockTimer != nil {
+		s.lockTimer.Stop()
+	}
+	s.lockTimer = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.Wallets.Lock(); err != nil {
+			log.Printf("rpc %s: locking the wallet: %v", s.Address, err)
+		}
+	})
+
+	return nil, nil
+}
+
+// walletLock locks the wallet file before the walletpassphrase timeout.
+func (s *Server) walletLock(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+	if !s.Wallets.Encrypted() {
+		return nil, walletError(wallet.ErrNotEncrypted)
+	}
+
+	if s.lockTimer != nil {
+		s.lockTimer.Stop()
+	}
+
+	return nil, s.Wallets.Lock()
+}
+
+func walletError(err error) error {
+	switch {
+	case errors.Is(err, wallet.ErrWalletLocked):
+		return &Error{CodeWalletLocked, err.Error()}
+	case errors.Is(err, wallet.ErrWrongPassphrase):
+		return &Error{CodeWrongPassphrase, err.Error()}
+	case errors.Is(err, wallet.ErrNotEncrypted), errors.Is(err, wallet.ErrAlreadyEncrypted):
+		return &Error{CodeWrongEncryptionState, err.Error()}
+	}
+
+	return err
+}
@@ -0,0 +1,193 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tensor-programming/golang-blockchain/blockchain"
+	"github.com/tensor-programming/golang-blockchain/storage"
+	"github.com/tensor-programming/golang-blockchain/wallet"
+)
+
+func newTestServer(t *testing.T, user, password string) *Server {
+	t.Helper()
+
+	w := wallet.MakeWallet()
+	chain, err := blockchain.InitBlockChain(string(w.Address()),
+		blockchain.Options{Network: "regtest", Store: storage.NewMemoryStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := wallet.CreateWallets(wallet.Options{DataDir: t.TempDir(), Network: "regtest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer("127.0.0.1:0", chain, wallets)
+	server.User = user
+	server.Password = password
+
+	return server
+}
+
+func TestStartNeedsCredentials(t *testing.T) {
+	for _, credentials := range [][2]string{{"", ""}, {"user", ""}, {"", "password"}} {
+		server := newTestServer(t, credentials[0], credentials[1])
+		if err := server.Start(); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Start with user %q and password %q = %v, want %v", credentials[0], credentials[1], err,
+				ErrNoCredentials)
+		}
+	}
+}
+
+func TestServerRejectsRequests(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	const body = `{"jsonrpc":"2.0","method":"getchaininfo","params":[],"id":1}`
+
+	tests := []struct {
+		name        string
+		user        string
+		password    string
+		contentType string
+		status      int
+	}{
+		{"authorized json", "user", "password", "application/json", http.StatusOK},
+		{"json with charset", "user", "password", "application/json; charset=utf-8", http.StatusOK},
+		{"no credentials", "", "", "application/json", http.StatusUnauthorized},
+		{"wrong password", "user", "secret", "application/json", http.StatusUnauthorized},
+		{"form post", "user", "password", "text/plain", http.StatusUnsupportedMediaType},
+		{"url encoded form", "user", "password", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"no content type", "user", "password", "", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodPost, "http://"+server.Address, strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != test.status {
+				t.Errorf("status %d, want %d", response.StatusCode, test.status)
+			}
+		})
+	}
+
+	var info ChainInfo
+	if err := NewClient("http://"+server.Address, "user", "password").Call("getchaininfo", &info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	client := NewClient("http://"+server.Address, "user", "password")
+
+	genesis, err := server.Chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := hex.EncodeToString(genesis.Transactions[0].ID)
+
+	var info TxInfo
+	if err := client.Call("gettransaction", &info, txid); err != nil {
+		t.Fatal(err)
+	}
+	if info.TxID != txid || info.BlockHash != hex.EncodeToString(genesis.Hash) || info.Confirmations != 1 {
+		t.Errorf("gettransaction = %+v, want %s in the genesis block", info, txid)
+	}
+
+	var rpcErr *Error
+	err = client.Call("gettransaction", &info, hex.EncodeToString(make([]byte, 32)))
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeNotFound {
+		t.Errorf("gettransaction of an unknown transaction = %v, want code %d", err, CodeNotFound)
+	}
+}
+
+func TestHandlerPanicReleasesLock(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+
+	handlers["panic"] = func(*Server, []json.RawMessage) (interface{}, error) {
+		panic("handler failed")
+	}
+	defer delete(handlers, "panic")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the handler did not panic")
+			}
+		}()
+		server.handle(json.RawMessage(`{"jsonrpc":"2.0","method":"panic","params":[],"id":1}`))
+	}()
+
+	done := make(chan *Response)
+	go func() {
+		done <- server.handle(json.RawMessage(`{"jsonrpc":"2.0","method":"getchaininfo","params":[],"id":2}`))
+	}()
+
+	select {
+	case response := <-done:
+		if response.Error != nil {
+			t.Fatalf("getchaininfo = %v", response.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server lock is still held after the panic")
+	}
+}
+
+func TestWalletSaveErrors(t *testing.T) {
+	server := newTestServer(t, "user", "password")
+
+	dataDir := t.TempDir()
+	wallets, err := wallet.CreateWallets(wallet.Options{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Wallets = wallets
+
+	// a file where the directory of the wallet file should be.
+	if err := os.RemoveAll(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range []string{
+		`{"jsonrpc":"2.0","method":"getnewaddress","params":[],"id":1}`,
+		`{"jsonrpc":"2.0","method":"encryptwallet","params":["passphrase"],"id":2}`,
+	} {
+		response := server.handle(json.RawMessage(request))
+		if response.Error == nil || response.Error.Code != CodeInternalError {
+			t.Errorf("%s = %+v, want an internal error", request, response)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import "github.com/dgraph-io/badger"
+
+// BadgerStore is a Store in a badger database.
+type BadgerStore struct {
+	DB *badger.DB
+}
+
+// OpenBadger opens the badger database of opts.
+func OpenBadger(opts badger.Options) (*BadgerStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BadgerStore{db}, nil
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := f(item.KeyCopy(nil), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t badgerTxn) Put(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (s *BadgerStore) View(f func(txn Reader) error) error {
+	return s.DB.View(func(txn *badger.Txn) error {
+		return f(badgerTxn{txn})
+	})
+}
+
+func (s *BadgerStore) Update(f func(txn Txn) error) error {
+	return s.DB.Update(func(txn *badger.Txn) error {
+		return f(badgerTxn{txn})
+	})
+}
+
+func (s *BadgerStore) Get(key []byte) (value []byte, err error) {
+	err = s.View(func(txn Reader) error {
+		value, err = txn.Get(key)
+
+		return err
+	})
+
+	return value, err
+}
+
+func (s *BadgerStore) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	return s.View(func(txn Reader) error {
+		return txn.Iterate(prefix, f)
+	})
+}
+
+func (s *BadgerStore) Put(key, value []byte) error {
+	return s.Update(func(txn Txn) error {
+		return txn.Put(key, value)
+	})
+}
+
+func (s *BadgerStore) Delete(key []byte) error {
+	return s.Update(func(txn Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (s *BadgerStore) Close() error {
+	return s.DB.Close()
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a Store in a map, for the chains that need no files. Update transactions run one at a time.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	update sync.Mutex
+	data   map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) get(key []byte) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.data[string(key)]
+
+	return bytes.Clone(value), ok
+}
+
+// keys returns the keys starting with prefix, sorted.
+func (s *MemoryStore) keys(prefix []byte) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type memoryReader struct {
+	store *MemoryStore
+}
+
+func (r memoryReader) Get(key []byte) ([]byte, error) {
+	value, ok := r.store.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return value, nil
+}
+
+func (r memoryReader) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	for _, key := range r.store.keys(prefix) {
+		value, ok := r.store.get([]byte(key))
+		if !ok {
+			continue
+		}
+		if err := f([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// memoryTxn keeps its writes aside until the transaction is committed, a nil value being a deletion.
+type memoryTxn struct {
+	memoryReader
+	writes map[string][]byte
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	if value, ok := t.writes[string(key)]; ok {
+		if value == nil {
+			return nil, ErrNotFound
+		}
+
+		return bytes.Clone(value), nil
+	}
+
+	return t.memoryReader.Get(key)
+}
+
+func (t *memoryTxn) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	keys := t.store.keys(prefix)
+	for key := range t.writes {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		value, err := t.Get([]byte(key))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := f([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTxn) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	t.writes[string(key)] = bytes.Clone(value)
+
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	t.writes[string(key)] = nil
+
+	return nil
+}
+
+func (s *MemoryStore) View(f func(txn Reader) error) error {
+	return f(memoryReader{s})
+}
+
+func (s *MemoryStore) Update(f func(txn Txn) error) error {
+	s.update.Lock()
+	defer s.update.Unlock()
+
+	txn := &memoryTxn{memoryReader{s}, make(map[string][]byte)}
+	if err := f(txn); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, value := range txn.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	return memoryReader{s}.Get(key)
+}
+
+func (s *MemoryStore) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	return memoryReader{s}.Iterate(prefix, f)
+}
+
+func (s *MemoryStore) Put(key, value []byte) error {
+	return s.Update(func(txn Txn) error {
+		return txn.Put(key, value)
+	})
+}
+
+func (s *MemoryStore) Delete(key []byte) error {
+	return s.Update(func(txn Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// Close does nothing, the data stays in memory.
+func (s *MemoryStore) Close() error {
+	return nil
+}
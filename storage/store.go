@@ -0,0 +1,34 @@
+// Package storage is the key value store the chain is kept in. Store is implemented on badger for the chains on
+// disk and in memory for the chains that need no files.
+package storage
+
+import "errors"
+
+// ErrNotFound is returned by Get when there is no value for the key.
+var ErrNotFound = errors.New("key not found")
+
+// Reader reads keys. The slices it returns belong to the caller.
+type Reader interface {
+	Get(key []byte) ([]byte, error)
+	// Iterate calls f with every key starting with prefix and its value, in key order. It stops at the first
+	// error f returns.
+	Iterate(prefix []byte, f func(key, value []byte) error) error
+}
+
+// Txn reads and writes keys in a transaction. Its reads see its own writes.
+type Txn interface {
+	Reader
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// Store is a key value store. Get, Iterate, Put and Delete each run in a transaction of their own, View and Update
+// group several operations in one.
+type Store interface {
+	Txn
+	// View runs f in a read only transaction.
+	View(f func(txn Reader) error) error
+	// Update runs f in a transaction whose writes are all applied if f returns nil, none of them otherwise.
+	Update(f func(txn Txn) error) error
+	Close() error
+}
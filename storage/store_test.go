@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+// stores returns every Store implementation, empty.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	badgerStore, err := OpenBadger(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { badgerStore.Close() })
+
+	return map[string]Store{"memory": NewMemoryStore(), "badger": badgerStore}
+}
+
+// dump lists the keys starting with prefix and their values, in the order Iterate gives them.
+func dump(t *testing.T, r Reader, prefix string) string {
+	t.Helper()
+
+	var list []string
+	err := r.Iterate([]byte(prefix), func(key, value []byte) error {
+		list = append(list, fmt.Sprintf("%s=%s", key, value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprint(list)
+}
+
+func TestStoreIterate(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"b-2", "a-1", "b-10", "b-1", "b", "c-1"} {
+				if err := store.Put([]byte(key), []byte("v"+key)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got, want := dump(t, store, "b-"), "[b-1=vb-1 b-10=vb-10 b-2=vb-2]"; got != want {
+				t.Errorf("Iterate b- %s, want %s", got, want)
+			}
+			if got, want := dump(t, store, "d"), "[]"; got != want {
+				t.Errorf("Iterate d %s, want %s", got, want)
+			}
+
+			stop := errors.New("stop")
+			calls := 0
+			err := store.Iterate([]byte("b"), func(key, value []byte) error {
+				calls++
+				return stop
+			})
+			if err != stop || calls != 1 {
+				t.Errorf("Iterate returned %v after %d calls, want the error of the first call", err, calls)
+			}
+
+			// a transaction iterates over its own writes merged with the stored keys.
+			err = store.Update(func(txn Txn) error {
+				if err := txn.Put([]byte("b-15"), []byte("new")); err != nil {
+					return err
+				}
+				if err := txn.Delete([]byte("b-10")); err != nil {
+					return err
+				}
+				if err := txn.Put([]byte("b-2"), []byte("changed")); err != nil {
+					return err
+				}
+
+				if got, want := dump(t, txn, "b-"), "[b-1=vb-1 b-15=new b-2=changed]"; got != want {
+					t.Errorf("Iterate b- in the transaction %s, want %s", got, want)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := dump(t, store, "b-"), "[b-1=vb-1 b-15=new b-2=changed]"; got != want {
+				t.Errorf("Iterate b- after the transaction %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestStoreUpdateRollsBack(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Put([]byte("kept"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+
+			failed := errors.New("failed")
+			err := store.Update(func(txn Txn) error {
+				if err := txn.Put([]byte("kept"), []byte("new")); err != nil {
+					return err
+				}
+				if err := txn.Put([]byte("added"), []byte("new")); err != nil {
+					return err
+				}
+
+				if value, err := txn.Get([]byte("added")); err != nil || string(value) != "new" {
+					t.Errorf("the transaction reads %q, %v from its own write", value, err)
+				}
+
+				return failed
+			})
+			if err != failed {
+				t.Fatalf("Update = %v, want the error of the callback", err)
+			}
+
+			if value, err := store.Get([]byte("kept")); err != nil || string(value) != "old" {
+				t.Errorf("kept is %q, %v after the rollback, want old", value, err)
+			}
+			if _, err := store.Get([]byte("added")); err != ErrNotFound {
+				t.Errorf("added: %v after the rollback, want %v", err, ErrNotFound)
+			}
+
+			if err := store.Delete([]byte("kept")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get([]byte("kept")); err != ErrNotFound {
+				t.Errorf("kept: %v after Delete, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestStoreValuesBelongToCaller(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			value := []byte("value")
+			if err := store.Put([]byte("key"), value); err != nil {
+				t.Fatal(err)
+			}
+			value[0] = 'X'
+
+			got, err := store.Get([]byte("key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[1] = 'X'
+
+			if again, err := store.Get([]byte("key")); err != nil || string(again) != "value" {
+				t.Errorf("stored value is %q, %v after the caller changed its slices", again, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Cost parameters of the scrypt key derivation of new encrypted wallet files. They are stored in the file, so they
+// can be raised without breaking existing files.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+var (
+	ErrWalletLocked     = errors.New("wallet is locked")
+	ErrWrongPassphrase  = errors.New("wrong wallet passphrase")
+	ErrNotEncrypted     = errors.New("wallet is not encrypted")
+	ErrAlreadyEncrypted = errors.New("wallet is already encrypted")
+)
+
+// encryption seals the seed and the private keys of a wallet file with AES-256-GCM, under a key derived from the
+// passphrase with scrypt.
+type encryption struct {
+	Salt       []byte
+	N, R, P    int
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// secrets is the plaintext of encryption.Ciphertext.
+type secrets struct {
+	Seed []byte            `json:",omitempty"`
+	Keys map[string][]byte // x509 encoded private keys by address
+}
+
+// newEncryption picks a random salt and returns the key the passphrase derives with it.
+func newEncryption(passphrase string) (*encryption, []byte, error) {
+	enc := &encryption{Salt: make([]byte, saltLen), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := enc.key(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, key, nil
+}
+
+func (enc *encryption) key(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), enc.Salt, enc.N, enc.R, enc.P, scryptKeyLen)
+}
+
+// seal encrypts s with a new nonce.
+func (enc *encryption) seal(key []byte, s secrets) error {
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	enc.Nonce = nonce
+	enc.Ciphertext = gcm.Seal(nil, nonce, plaintext, nil)
+
+	return nil
+}
+
+// open decrypts the secrets. A key derived from the wrong passphrase fails the authentication of GCM and returns
+// ErrWrongPassphrase.
+func (enc *encryption) open(key []byte) (secrets, error) {
+	var s secrets
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return s, err
+	}
+
+	plaintext, err := gcm.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		return s, ErrWrongPassphrase
+	}
+
+	err = json.Unmarshal(plaintext, &s)
+
+	return s, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,143 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedOffset is added to the index of a hardened child, which is derived from the private key of its parent.
+const HardenedOffset uint32 = 0x80000000
+
+// masterKeySalt is the HMAC key SLIP-0010 uses for the master key of a P-256 seed.
+var masterKeySalt = []byte("Nist256p1 seed")
+
+var (
+	ErrInvalidSeed = errors.New("seed must be 16 to 64 bytes")
+	ErrInvalidPath = errors.New("invalid derivation path")
+)
+
+// ExtendedKey is a private key with the chain code its children are derived with. Derivation follows BIP32 on the
+// P-256 curve of the wallets, as specified by SLIP-0010.
+type ExtendedKey struct {
+	Key       []byte // 32 byte private scalar
+	ChainCode []byte
+	Depth     uint8
+	Index     uint32 // index of the key under its parent
+}
+
+// NewMasterKey derives the root key of a seed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeed
+	}
+
+	sum := hmacSHA512(masterKeySalt, seed)
+	for {
+		if key := new(big.Int).SetBytes(sum[:32]); key.Sign() != 0 && key.Cmp(curveOrder()) < 0 {
+			return &ExtendedKey{Key: sum[:32], ChainCode: sum[32:]}, nil
+		}
+		sum = hmacSHA512(masterKeySalt, sum)
+	}
+}
+
+// Child derives the child key at index, a hardened one when index is at least HardenedOffset.
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey {
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.Key...)
+	} else {
+		data = k.publicKey()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	n := curveOrder()
+	for {
+		sum := hmacSHA512(k.ChainCode, data)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, new(big.Int).SetBytes(k.Key))
+		child.Mod(child, n)
+
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			key := make([]byte, 32)
+			child.FillBytes(key)
+
+			return &ExtendedKey{Key: key, ChainCode: sum[32:], Depth: k.Depth + 1, Index: index}
+		}
+
+		// SLIP-0010 retries with the right half of the digest instead of skipping to the next index.
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[32:]...), index)
+	}
+}
+
+// Derive follows a path of child indexes from k.
+func (k *ExtendedKey) Derive(path []uint32) *ExtendedKey {
+	key := k
+	for _, index := range path {
+		key = key.Child(index)
+	}
+
+	return key
+}
+
+// Wallet returns the wallet of the private key.
+func (k *ExtendedKey) Wallet() *Wallet {
+	curve := elliptic.P256()
+
+	private := ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.Key)}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(k.Key)
+
+	return &Wallet{private, PublicKeyBytes(&private.PublicKey)}
+}
+
+func (k *ExtendedKey) publicKey() []byte {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(k.Key)
+
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+// ParsePath parses a derivation path such as m/44'/0'/0'/0, where ' or h marks a hardened index.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q does not start with m", ErrInvalidPath, path)
+	}
+
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if trimmed := strings.TrimRight(part, "'h"); len(trimmed) == len(part)-1 {
+			part = trimmed
+			offset = HardenedOffset
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+
+	return indexes, nil
+}
+
+func curveOrder() *big.Int {
+	return elliptic.P256().Params().N
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// slip10Vectors are the nist256p1 test vectors of SLIP-0010. The last two need the retry of a derivation.
+var slip10Vectors = []struct {
+	seed      string
+	path      string
+	chainCode string
+	key       string
+}{
+	{"000102030405060708090a0b0c0d0e0f", "m",
+		"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+		"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'",
+		"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+		"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1",
+		"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+		"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'",
+		"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+		"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2",
+		"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+		"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000",
+		"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+		"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+	{"000102030405060708090a0b0c0d0e0f", "m/28578'",
+		"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+		"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+	{"000102030405060708090a0b0c0d0e0f", "m/28578'/33941",
+		"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+		"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+	{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m",
+		"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+		"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+}
+
+func TestDeriveVectors(t *testing.T) {
+	for _, vector := range slip10Vectors {
+		seed, _ := hex.DecodeString(vector.seed)
+		master, err := NewMasterKey(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := ParsePath(vector.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := master.Derive(path)
+		if chainCode := hex.EncodeToString(key.ChainCode); chainCode != vector.chainCode {
+			t.Errorf("%s %s: chain code %s, want %s", vector.seed, vector.path, chainCode, vector.chainCode)
+		}
+		if private := hex.EncodeToString(key.Key); private != vector.key {
+			t.Errorf("%s %s: key %s, want %s", vector.seed, vector.path, private, vector.key)
+		}
+		if int(key.Depth) != len(path) {
+			t.Errorf("%s %s: depth %d", vector.seed, vector.path, key.Depth)
+		}
+	}
+}
+
+func TestWalletPublicKeyPadded(t *testing.T) {
+	master, err := NewMasterKey([]byte("a seed long enough for a master key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	short := 0
+	for i := uint32(0); i < 1000 && short < 3; i++ {
+		w := master.Child(i).Wallet()
+		x, y := w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y
+		if len(x.Bytes()) < coordinateSize || len(y.Bytes()) < coordinateSize {
+			short++
+		}
+
+		if len(w.PublicKey) != 2*coordinateSize {
+			t.Fatalf("child %d: public key of %d bytes", i, len(w.PublicKey))
+		}
+		if new(big.Int).SetBytes(w.PublicKey[:coordinateSize]).Cmp(x) != 0 ||
+			new(big.Int).SetBytes(w.PublicKey[coordinateSize:]).Cmp(y) != 0 {
+			t.Fatalf("child %d: public key %x does not split into %x and %x", i, w.PublicKey, x, y)
+		}
+	}
+
+	if short == 0 {
+		t.Fatal("no key with a short coordinate was derived")
+	}
+}
@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// english.txt is the English word list of BIP39.
+//
+//go:embed english.txt
+var english string
+
+var (
+	wordList  = strings.Fields(english)
+	wordIndex = make(map[string]int, len(wordList))
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndex[word] = i
+	}
+}
+
+var (
+	ErrInvalidEntropy  = errors.New("entropy must be 128 to 256 bits, a multiple of 32")
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+)
+
+// GenerateMnemonic returns a BIP39 mnemonic encoding bits of random entropy: 12 words for 128 bits up to 24 words
+// for 256 bits.
+func GenerateMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidEntropy
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return NewMnemonic(entropy)
+}
+
+// NewMnemonic encodes entropy as BIP39 words. The entropy is followed by the first len(entropy)/4 bits of its
+// SHA-256 checksum, and every 11 bits select a word.
+func NewMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidEntropy
+	}
+
+	checksumBits := uint(bits / 32)
+	hash := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (bits+int(checksumBits))/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a mnemonic back to its entropy. It returns ErrInvalidMnemonic for unknown words, a
+// wrong number of words or a checksum mismatch.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	data.Rsh(data, checksumBits)
+
+	entropy := make([]byte, len(words)*11*32/33/8)
+	data.FillBytes(entropy)
+
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// NewSeed derives the 64 byte seed of a mnemonic with PBKDF2, salted with the optional passphrase. The mnemonic is
+// not validated, use MnemonicToEntropy first. Words and passphrase are used as given, without Unicode normalization.
+func NewSeed(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// bip39Vectors are from the BIP39 reference test vectors, whose seeds use the passphrase TREZOR.
+var bip39Vectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		strings.Repeat("abandon ", 23) + "art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, vector := range bip39Vectors {
+		entropy, _ := hex.DecodeString(vector.entropy)
+
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != vector.mnemonic {
+			t.Errorf("%s: mnemonic %q, want %q", vector.entropy, mnemonic, vector.mnemonic)
+		}
+
+		decoded, err := MnemonicToEntropy(vector.mnemonic)
+		if err != nil {
+			t.Fatalf("%s: %v", vector.entropy, err)
+		}
+		if !bytes.Equal(decoded, entropy) {
+			t.Errorf("%s: decoded entropy %x", vector.entropy, decoded)
+		}
+
+		if seed := hex.EncodeToString(NewSeed(vector.mnemonic, "TREZOR")); seed != vector.seed {
+			t.Errorf("%s: seed %s, want %s", vector.entropy, seed, vector.seed)
+		}
+	}
+}
+
+func TestMnemonicToEntropyRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"bad checksum", strings.Repeat("abandon ", 12)},
+		{"unknown word", strings.Repeat("abandon ", 11) + "abut"},
+		{"too few words", strings.Repeat("abandon ", 8) + "about"},
+		{"word count not a multiple of 3", strings.Repeat("abandon ", 12) + "about"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := MnemonicToEntropy(test.mnemonic); !errors.Is(err, ErrInvalidMnemonic) {
+				t.Errorf("MnemonicToEntropy = %v, want %v", err, ErrInvalidMnemonic)
+			}
+		})
+	}
+}
+
+func TestNewMnemonicRejectsEntropySize(t *testing.T) {
+	for _, size := range []int{0, 15, 17, 33} {
+		if _, err := NewMnemonic(make([]byte, size)); !errors.Is(err, ErrInvalidEntropy) {
+			t.Errorf("%d bytes: NewMnemonic = %v, want %v", size, err, ErrInvalidEntropy)
+		}
+	}
+}
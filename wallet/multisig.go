@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// MultisigVersion is the version byte of multisig addresses, which hash the multisig instead of a public key.
+	MultisigVersion = byte(0x05)
+	// MaxMultisigKeys bounds the number of public keys of a multisig.
+	MaxMultisigKeys = 16
+)
+
+var ErrInvalidMultisig = errors.New("invalid multisig")
+
+// Multisig locks outputs to M signatures out of the N public keys. The keys are kept sorted, so the participants
+// get the same address whatever order they list the keys in.
+type Multisig struct {
+	M       int
+	PubKeys [][]byte
+}
+
+// NewMultisig creates the m of len(pubKeys) multisig of the keys.
+func NewMultisig(m int, pubKeys [][]byte) (*Multisig, error) {
+	keys := make([][]byte, len(pubKeys))
+	copy(keys, pubKeys)
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	ms := &Multisig{m, keys}
+	if err := ms.validate(); err != nil {
+		return nil, err
+	}
+
+	return ms, nil
+}
+
+func (ms *Multisig) validate() error {
+	n := len(ms.PubKeys)
+	if n == 0 || n > MaxMultisigKeys || ms.M < 1 || ms.M > n {
+		return fmt.Errorf("%w: %d of %d keys", ErrInvalidMultisig, ms.M, n)
+	}
+
+	for i, key := range ms.PubKeys {
+		if len(key) == 0 || len(key) > 255 {
+			return fmt.Errorf("%w: key %d has %d bytes", ErrInvalidMultisig, i, len(key))
+		}
+		if i > 0 && bytes.Compare(ms.PubKeys[i-1], key) >= 0 {
+			return fmt.Errorf("%w: keys are not sorted or repeat", ErrInvalidMultisig)
+		}
+	}
+
+	return nil
+}
+
+// Serialize encodes the multisig as M, N and the length prefixed keys. Spending inputs carry it so it can be
+// checked against the hash of the output.
+func (ms *Multisig) Serialize() []byte {
+	data := []byte{byte(ms.M), byte(len(ms.PubKeys))}
+	for _, key := range ms.PubKeys {
+		data = append(data, byte(len(key)))
+		data = append(data, key...)
+	}
+
+	return data
+}
+
+// DeserializeMultisig decodes a multisig produced by Serialize.
+func DeserializeMultisig(data []byte) (*Multisig, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidMultisig, len(data))
+	}
+
+	ms := &Multisig{M: int(data[0])}
+	n := int(data[1])
+	data = data[2:]
+
+	for i := 0; i < n; i++ {
+		if len(data) == 0 || len(data) < 1+int(data[0]) {
+			return nil, fmt.Errorf("%w: key %d is truncated", ErrInvalidMultisig, i)
+		}
+		ms.PubKeys = append(ms.PubKeys, data[1:1+int(data[0])])
+		data = data[1+int(data[0]):]
+	}
+
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidMultisig, len(data))
+	}
+
+	if err := ms.validate(); err != nil {
+		return nil, err
+	}
+
+	return ms, nil
+}
+
+// Hash is what outputs paying the multisig are locked to, in place of a public key hash.
+func (ms *Multisig) Hash() []byte {
+	return PublicKeyHash(ms.Serialize())
+}
+
+// Address encodes the hash of the multisig with MultisigVersion.
+func (ms *Multisig) Address() []byte {
+	return MultisigHashToAddress(ms.Hash())
+}
+
+// MultisigHashToAddress encodes the hash of a multisig as an address.
+func MultisigHashToAddress(hash []byte) []byte {
+	return versionedAddress(MultisigVersion, hash)
+}
+
+// KeyIndex returns the position of pubKey among the keys, or -1 when it is not one of them.
+func (ms *Multisig) KeyIndex(pubKey []byte) int {
+	for i, key := range ms.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// AddressVersion returns the version byte of a valid address.
+func AddressVersion(address string) (byte, bool) {
+	if !ValidateAddress(address) {
+		return 0, false
+	}
+
+	return Base58Decode([]byte(address))[0], true
+}
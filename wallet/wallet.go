@@ -8,12 +8,15 @@ import (
 	"crypto/sha256"
 	"log"
 
+	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
 	checksumLength = 4
 	version        = byte(0x00)
+	// coordinateSize is the length of a coordinate of a P-256 point.
+	coordinateSize = 32
 )
 
 type Wallet struct {
@@ -21,10 +24,22 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
+// Locked reports whether the private key is missing, as in the wallets of a locked wallet file.
+func (w Wallet) Locked() bool {
+	return w.PrivateKey.D == nil
+}
+
 // Address contains base58 of version, checksum, pubHash.
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
+	return PubKeyHashToAddress(PublicKeyHash(w.PublicKey))
+}
+
+// PubKeyHashToAddress encodes a public key hash as an address.
+func PubKeyHashToAddress(pubHash []byte) []byte {
+	return versionedAddress(version, pubHash)
+}
 
+func versionedAddress(version byte, pubHash []byte) []byte {
 	versionedHash := append([]byte{version}, pubHash...)
 	checksum := Checksum(versionedHash)
 
@@ -42,8 +57,17 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+	return *private, PublicKeyBytes(&private.PublicKey)
+}
+
+// PublicKeyBytes encodes a public key as its X and Y coordinates, each padded to coordinateSize bytes so the key
+// always splits in the middle.
+func PublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	key := make([]byte, 2*coordinateSize)
+	pub.X.FillBytes(key[:coordinateSize])
+	pub.Y.FillBytes(key[coordinateSize:])
+
+	return key
 }
 
 func MakeWallet() *Wallet {
@@ -81,7 +105,10 @@ func Checksum(payload []byte) []byte {
 // [Pub Key Hash] 248bd9e7a51b7dd07aba9766a7c62d5020790280
 // [CheckSum] 2bc6c767
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash, err := base58.Decode(address)
+	if err != nil || len(pubKeyHash) <= checksumLength {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
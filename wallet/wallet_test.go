@@ -0,0 +1,30 @@
+package wallet
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNewKeyPairPadded(t *testing.T) {
+	// about one key in 128 has a coordinate with a leading zero byte.
+	short := 0
+	for i := 0; i < 5000 && short < 3; i++ {
+		private, pub := NewKeyPair()
+		x, y := private.PublicKey.X, private.PublicKey.Y
+		if len(x.Bytes()) < coordinateSize || len(y.Bytes()) < coordinateSize {
+			short++
+		}
+
+		if len(pub) != 2*coordinateSize {
+			t.Fatalf("public key of %d bytes", len(pub))
+		}
+		if new(big.Int).SetBytes(pub[:coordinateSize]).Cmp(x) != 0 ||
+			new(big.Int).SetBytes(pub[coordinateSize:]).Cmp(y) != 0 {
+			t.Fatalf("public key %x does not split into %x and %x", pub, x, y)
+		}
+	}
+
+	if short == 0 {
+		t.Fatal("no key with a short coordinate was generated")
+	}
+}
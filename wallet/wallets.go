@@ -4,44 +4,295 @@ import (
 	"bytes"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
 )
 
-const walletFile = "./tmp/wallets.json"
+const (
+	walletFile     = "wallets.json"
+	defaultDataDir = "./tmp"
+	mainNet        = "mainnet"
+)
+
+// Options tell where the wallet file is. Like chains, mainnet wallets live at the root of the data directory and
+// the other networks each get a sub directory. The zero value is the mainnet wallet file in ./tmp.
+type Options struct {
+	DataDir string // root of the data directory, ./tmp when empty
+	Network string // network name, mainnet when empty
+}
+
+// File is the path of the wallet file.
+func (opts Options) File() string {
+	dir := opts.DataDir
+	if dir == "" {
+		dir = defaultDataDir
+	}
+
+	if opts.Network != "" && opts.Network != mainNet {
+		dir = filepath.Join(dir, opts.Network)
+	}
+
+	return filepath.Join(dir, walletFile)
+}
 
+// ErrSeedExists is returned when a wallet file that already has a seed is given another one.
+var ErrSeedExists = errors.New("wallet file already has a different seed")
+
+// DerivationPath is the path of the chain of receiving keys under the master key of the seed. Key i of the chain
+// is its child i.
+const DerivationPath = "m/44'/0'/0'/0"
+
+// Wallets holds the keys of a wallet file. When Seed is set, new keys are derived from it, NextIndex being the
+// first child of DerivationPath not handed out yet, so the keys can be restored from the seed alone.
+//
+// Multisigs are the multisig addresses the wallet file takes part in, by address. They hold no secret and are
+// never encrypted.
+//
+// An encrypted wallet file only holds the public keys in the clear. It is loaded locked: the wallets have no
+// private key and Seed is nil until Unlock.
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets   map[string]*Wallet
+	Seed      []byte
+	NextIndex uint32
+	Multisigs map[string]*Multisig
+	file      string
+
+	encryption *encryption
+	key        []byte // key of encryption while unlocked
 }
 
 type SerializableWallet struct {
-	PrivateKey []byte
+	PrivateKey []byte `json:",omitempty"`
 	PublicKey  []byte
 }
 
-func CreateWallets() (*Wallets, error) {
-	wallets := Wallets{}
+// storedWallets is the layout of the wallet file. Files written before seeds were supported hold only the Wallets
+// map.
+type storedWallets struct {
+	Seed       []byte `json:",omitempty"`
+	NextIndex  uint32 `json:",omitempty"`
+	Wallets    map[string]*SerializableWallet
+	Encryption *encryption       `json:",omitempty"`
+	Multisigs  map[string][]byte `json:",omitempty"` // serialized multisigs by address
+}
+
+// CreateWallets loads the wallet file of opts. A missing file gives an empty set of wallets, the file is created by
+// the first SaveFile.
+func CreateWallets(opts Options) (*Wallets, error) {
+	wallets := Wallets{file: opts.File()}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Multisigs = make(map[string]*Multisig)
 
 	err := wallets.LoadFile()
-
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
 	return &wallets, nil
 }
 
-func (ws *Wallets) AddWallet() string {
+// AddWallet adds a new key and returns its address. The key is the next one of the seed when there is a seed,
+// a random one otherwise.
+func (ws *Wallets) AddWallet() (string, error) {
+	if ws.Locked() {
+		return "", ErrWalletLocked
+	}
+
 	wallet := MakeWallet()
+	if ws.Seed != nil {
+		var err error
+		if wallet, err = ws.DeriveWallet(ws.NextIndex); err != nil {
+			return "", err
+		}
+		ws.NextIndex++
+	}
 	address := fmt.Sprintf("%s", wallet.Address())
 
 	ws.Wallets[address] = wallet
 
+	return address, nil
+}
+
+// AddMultisig adds a multisig and returns its address.
+func (ws *Wallets) AddMultisig(ms *Multisig) string {
+	address := string(ms.Address())
+	ws.Multisigs[address] = ms
+
 	return address
 }
 
+// SetSeed makes the wallets derive their keys from seed. Setting the seed a file already has is a no-op, another
+// seed returns ErrSeedExists.
+func (ws *Wallets) SetSeed(seed []byte) error {
+	if ws.Locked() {
+		return ErrWalletLocked
+	}
+
+	if ws.Seed != nil {
+		if bytes.Equal(ws.Seed, seed) {
+			return nil
+		}
+		return ErrSeedExists
+	}
+
+	if _, err := NewMasterKey(seed); err != nil {
+		return err
+	}
+	ws.Seed = seed
+
+	return nil
+}
+
+// DeriveWallet returns key index of DerivationPath under the seed.
+func (ws *Wallets) DeriveWallet(index uint32) (*Wallet, error) {
+	if ws.Locked() {
+		return nil, ErrWalletLocked
+	}
+
+	master, err := NewMasterKey(ws.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := ParsePath(DerivationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return master.Derive(path).Child(index).Wallet(), nil
+}
+
+// Discover scans the keys of the seed for the ones in use, as told by used, and adds them. The scan stops after
+// gapLimit unused keys in a row, so a key handed out after gapLimit unused ones is not found. It returns the
+// addresses of the keys in use.
+func (ws *Wallets) Discover(gapLimit int, used func(address string) (bool, error)) ([]string, error) {
+	var found []string
+
+	for index, gap := uint32(0), 0; gap < gapLimit; index++ {
+		wallet, err := ws.DeriveWallet(index)
+		if err != nil {
+			return nil, err
+		}
+		address := string(wallet.Address())
+
+		inUse, err := used(address)
+		if err != nil {
+			return nil, err
+		}
+		if !inUse {
+			gap++
+			continue
+		}
+
+		gap = 0
+		ws.Wallets[address] = wallet
+		found = append(found, address)
+		if index >= ws.NextIndex {
+			ws.NextIndex = index + 1
+		}
+	}
+
+	return found, nil
+}
+
+// Encrypted reports whether the wallet file is encrypted.
+func (ws *Wallets) Encrypted() bool {
+	return ws.encryption != nil
+}
+
+// Locked reports whether the wallet file is encrypted and its private keys are not available.
+func (ws *Wallets) Locked() bool {
+	return ws.encryption != nil && ws.key == nil
+}
+
+// Encrypt encrypts the seed and the private keys with passphrase and locks the wallets. The file is only
+// rewritten by the next SaveFile.
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.Encrypted() {
+		return ErrAlreadyEncrypted
+	}
+
+	enc, key, err := newEncryption(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.encryption = enc
+	ws.key = key
+
+	return ws.Lock()
+}
+
+// Unlock decrypts the seed and the private keys. It returns ErrWrongPassphrase when passphrase does not decrypt
+// them.
+func (ws *Wallets) Unlock(passphrase string) error {
+	if !ws.Encrypted() {
+		return ErrNotEncrypted
+	}
+
+	key, err := ws.encryption.key(passphrase)
+	if err != nil {
+		return err
+	}
+
+	s, err := ws.encryption.open(key)
+	if err != nil {
+		return err
+	}
+
+	for address, keyBytes := range s.Keys {
+		privKey, err := x509.ParseECPrivateKey(keyBytes)
+		if err != nil {
+			return err
+		}
+
+		publicKey := PublicKeyBytes(&privKey.PublicKey)
+		if wallet, ok := ws.Wallets[address]; ok {
+			publicKey = wallet.PublicKey
+		}
+		ws.Wallets[address] = &Wallet{PrivateKey: *privKey, PublicKey: publicKey}
+	}
+	ws.Seed = s.Seed
+	ws.key = key
+
+	return nil
+}
+
+// Lock seals the seed and the private keys, including the ones added since Unlock, and drops them from memory.
+// It does nothing on a file that is not encrypted.
+func (ws *Wallets) Lock() error {
+	if !ws.Encrypted() || ws.Locked() {
+		return nil
+	}
+
+	if err := ws.seal(); err != nil {
+		return err
+	}
+
+	for address, wallet := range ws.Wallets {
+		ws.Wallets[address] = &Wallet{PublicKey: wallet.PublicKey}
+	}
+	ws.Seed = nil
+	ws.key = nil
+
+	return nil
+}
+
+func (ws *Wallets) seal() error {
+	s := secrets{Seed: ws.Seed, Keys: make(map[string][]byte)}
+
+	for address, wallet := range ws.Wallets {
+		keyBytes, err := x509.MarshalECPrivateKey(&wallet.PrivateKey)
+		if err != nil {
+			return err
+		}
+		s.Keys[address] = keyBytes
+	}
+
+	return ws.encryption.seal(ws.key, s)
+}
+
 func (ws *Wallets) GetAllAddresses() []string {
 	var addresses []string
 
@@ -57,24 +308,45 @@ func (ws *Wallets) GetWallet(address string) Wallet {
 }
 
 func (ws *Wallets) LoadFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	content, err := os.ReadFile(ws.file)
+	if err != nil {
 		return err
 	}
 
-	fileContent, err := os.Open(walletFile)
-	if err != nil {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
 		return err
 	}
 
-	var serializedWallet map[string]*SerializableWallet
-
-	decoder := json.NewDecoder(fileContent)
-	err = decoder.Decode(&serializedWallet)
+	var file storedWallets
+	if _, ok := fields["Wallets"]; ok {
+		err = json.Unmarshal(content, &file)
+	} else {
+		err = json.Unmarshal(content, &file.Wallets)
+	}
 	if err != nil {
 		return err
 	}
 
-	for address, serializedWallet := range serializedWallet {
+	for address, data := range file.Multisigs {
+		ms, err := DeserializeMultisig(data)
+		if err != nil {
+			return err
+		}
+		ws.Multisigs[address] = ms
+	}
+
+	if file.Encryption != nil {
+		for address, serializedWallet := range file.Wallets {
+			ws.Wallets[address] = &Wallet{PublicKey: serializedWallet.PublicKey}
+		}
+		ws.NextIndex = file.NextIndex
+		ws.encryption = file.Encryption
+
+		return nil
+	}
+
+	for address, serializedWallet := range file.Wallets {
 		privKey, err := x509.ParseECPrivateKey(serializedWallet.PrivateKey)
 		if err != nil {
 			return err
@@ -85,24 +357,34 @@ func (ws *Wallets) LoadFile() error {
 			PublicKey:  serializedWallet.PublicKey,
 		}
 	}
+	ws.Seed = file.Seed
+	ws.NextIndex = file.NextIndex
 
 	return nil
 }
 
-func (ws *Wallets) SaveFile() {
+// SaveFile writes the wallet file, readable by its owner only. An unlocked encrypted file is sealed again first,
+// so the keys added since Unlock are kept.
+func (ws *Wallets) SaveFile() error {
 	var content bytes.Buffer
 
+	if ws.Encrypted() && !ws.Locked() {
+		if err := ws.seal(); err != nil {
+			return err
+		}
+	}
+
 	serializedWallets := make(map[string]*SerializableWallet)
 
 	for _, wallet := range ws.Wallets {
-		privKeyBytes, err := x509.MarshalECPrivateKey(&wallet.PrivateKey)
-		if err != nil {
-			log.Panic(err)
-		}
+		serializedWallet := &SerializableWallet{PublicKey: wallet.PublicKey}
 
-		serializedWallet := &SerializableWallet{
-			PrivateKey: privKeyBytes,
-			PublicKey:  wallet.PublicKey,
+		if !ws.Encrypted() {
+			privKeyBytes, err := x509.MarshalECPrivateKey(&wallet.PrivateKey)
+			if err != nil {
+				return err
+			}
+			serializedWallet.PrivateKey = privKeyBytes
 		}
 
 		address := fmt.Sprintf("%s", wallet.Address())
@@ -110,14 +392,33 @@ func (ws *Wallets) SaveFile() {
 		serializedWallets[address] = serializedWallet
 	}
 
+	file := storedWallets{ws.Seed, ws.NextIndex, serializedWallets, ws.encryption, nil}
+	if len(ws.Multisigs) > 0 {
+		file.Multisigs = make(map[string][]byte)
+		for address, ms := range ws.Multisigs {
+			file.Multisigs[address] = ms.Serialize()
+		}
+	}
+	if ws.Encrypted() {
+		file.Seed = nil
+	}
+
 	jsonEncoder := json.NewEncoder(&content)
-	err := jsonEncoder.Encode(serializedWallets)
+	err := jsonEncoder.Encode(file)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(ws.file), 0700)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	err = os.WriteFile(walletFile, content.Bytes(), 0777)
+	err = os.WriteFile(ws.file, content.Bytes(), 0600)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(ws.file, 0600)
 }
@@ -0,0 +1,168 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"testing"
+)
+
+// newTestWallets creates a wallet file in a temporary directory with a seed and two of its keys.
+func newTestWallets(t *testing.T) (*Wallets, Options) {
+	t.Helper()
+
+	opts := Options{DataDir: t.TempDir(), Network: "regtest"}
+	wallets, err := CreateWallets(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SetSeed(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := wallets.AddWallet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return wallets, opts
+}
+
+// privateKeys returns the private scalars of the wallets by address.
+func privateKeys(ws *Wallets) map[string][]byte {
+	keys := make(map[string][]byte)
+	for address, w := range ws.Wallets {
+		if !w.Locked() {
+			keys[address] = w.PrivateKey.D.Bytes()
+		}
+	}
+
+	return keys
+}
+
+func checkKeys(t *testing.T, ws *Wallets, want map[string][]byte) {
+	t.Helper()
+
+	got := privateKeys(ws)
+	if len(got) != len(want) {
+		t.Fatalf("%d private keys, want %d", len(got), len(want))
+	}
+	for address, key := range want {
+		if !bytes.Equal(got[address], key) {
+			t.Errorf("private key of %s changed", address)
+		}
+	}
+}
+
+func TestEncryptedWalletFile(t *testing.T) {
+	wallets, opts := newTestWallets(t)
+	seed := wallets.Seed
+	keys := privateKeys(wallets)
+
+	if err := wallets.Encrypt("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(opts.File())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte(base64.StdEncoding.EncodeToString(seed))) {
+		t.Error("the seed is stored in the clear")
+	}
+
+	loaded, err := CreateWallets(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Encrypted() || !loaded.Locked() {
+		t.Fatal("the loaded wallet file is not locked")
+	}
+	if len(loaded.Wallets) != len(keys) || len(privateKeys(loaded)) != 0 || loaded.Seed != nil {
+		t.Fatal("the locked wallet file has secrets or lost its public keys")
+	}
+
+	if err := loaded.Unlock("wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("Unlock = %v, want %v", err, ErrWrongPassphrase)
+	}
+	if !loaded.Locked() {
+		t.Fatal("a wrong passphrase unlocked the wallet file")
+	}
+
+	if err := loaded.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Seed, seed) {
+		t.Error("the seed changed")
+	}
+	checkKeys(t, loaded, keys)
+
+	// a key added while unlocked is sealed by SaveFile.
+	address, err := loaded.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys[address] = loaded.Wallets[address].PrivateKey.D.Bytes()
+	if err := loaded.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := CreateWallets(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, reloaded, keys)
+
+	if err := reloaded.Encrypt("other"); !errors.Is(err, ErrAlreadyEncrypted) {
+		t.Errorf("Encrypt = %v, want %v", err, ErrAlreadyEncrypted)
+	}
+}
+
+func TestLockedWallets(t *testing.T) {
+	wallets, _ := newTestWallets(t)
+	if err := wallets.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if wallets.Locked() {
+		t.Fatal("Lock locked a wallet file that is not encrypted")
+	}
+	if err := wallets.Unlock("passphrase"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Unlock = %v, want %v", err, ErrNotEncrypted)
+	}
+
+	if err := wallets.Encrypt("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(privateKeys(wallets)) != 0 || wallets.Seed != nil {
+		t.Fatal("Lock left secrets in memory")
+	}
+	for address, w := range wallets.Wallets {
+		if string(w.Address()) != address {
+			t.Errorf("Lock lost the public key of %s", address)
+		}
+	}
+
+	if _, err := wallets.AddWallet(); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("AddWallet = %v, want %v", err, ErrWalletLocked)
+	}
+	if _, err := wallets.DeriveWallet(0); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("DeriveWallet = %v, want %v", err, ErrWalletLocked)
+	}
+	if err := wallets.SetSeed(bytes.Repeat([]byte{8}, 32)); !errors.Is(err, ErrWalletLocked) {
+		t.Errorf("SetSeed = %v, want %v", err, ErrWalletLocked)
+	}
+}